@@ -0,0 +1,59 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+//GrantRead lets entity read objectName in a GCS bucket, e.g. to share a
+//single object with another project without opening the whole bucket
+// - entity is an ACL entity such as 'user-sa@p.iam.gserviceaccount.com',
+//'group-team@example.com', 'domain-example.com' or 'allUsers'.
+// - Object ACLs are disabled on buckets with uniform bucket-level access,
+//where it fails with an error saying to grant access through IAM instead.
+func (c *Client) GrantRead(bucket string, objectName string, entity string) error {
+	return c.setACL(bucket, objectName, entity, "granting read access", func(ctx context.Context, acl *storage.ACLHandle) error {
+		return acl.Set(ctx, storage.ACLEntity(entity), storage.RoleReader)
+	})
+}
+
+//RevokeRead removes the ACL entry of entity from objectName in a GCS
+//bucket, as granted by GrantRead. Access entity has through IAM or
+//other ACL entries, such as those of a group it is in, is unaffected.
+func (c *Client) RevokeRead(bucket string, objectName string, entity string) error {
+	return c.setACL(bucket, objectName, entity, "revoking read access", func(ctx context.Context, acl *storage.ACLHandle) error {
+		return acl.Delete(ctx, storage.ACLEntity(entity))
+	})
+}
+
+//setACL runs the ACL change update, described by action, for entity on
+//objectName.
+func (c *Client) setACL(bucket string, objectName string, entity string, action string, update func(ctx context.Context, acl *storage.ACLHandle) error) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	if entity == "" {
+		return fmt.Errorf("invalid ACL entity %q", entity)
+	}
+	if c.skipDryRun(action, "bucket", bucket, "object", objectName, "entity", entity) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+
+	err := update(ctx, c.storageBucket(bucket).Object(objectName).ACL())
+	if err != nil {
+		c.log.Error("GCS: Error updating object ACL", "object", objectName, "entity", entity, "err", err)
+		if isUniformAccessRejection(err) {
+			return fmt.Errorf("unable to change the ACL of gs://%s/%s: the bucket has uniform bucket-level access, "+
+				"so object ACLs are disabled; grant %s access through IAM on the bucket instead: %w", bucket, objectName, entity, err)
+		}
+		return gcsError(bucket, objectName, err)
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+package gcs
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//archiveContentType is the content type of archives written by Archive.
+const archiveContentType = "application/x-tar"
+
+//Archive writes a tar of every file under localDir to objectName in GCS
+//bucket, compressed with gzip, in the manner of 'tar -cz localDir'
+// - The tar is streamed into the upload as the tree is walked, with no
+//intermediate file, and the object has content type 'application/x-tar'.
+// - Entries keep their path relative to localDir, their mode and their
+//modification time. Symbolic links are stored as links, not followed.
+// - Sockets, devices and other special files are skipped.
+// - If a file cannot be read the upload is aborted and no object is
+//committed.
+func (c *Client) Archive(bucket string, objectName string, localDir string) error {
+	_, err := c.ArchiveWithOptions(c.ctx, bucket, objectName, localDir, defaultUploadOpts)
+	return err
+}
+
+//ArchiveWithOptions is like Archive but runs under ctx and uploads the
+//tar with opts. The archive is always compressed, with opts.Encoding, and
+//its content type defaults to 'application/x-tar'.
+func (c *Client) ArchiveWithOptions(ctx context.Context, bucket string, objectName string, localDir string, opts UploadOpts) (*UploadResult, error) {
+	opts.Compress = CompressAlways
+	opts.AlreadyCompressed = false
+	opts.MinCompressSize = 0
+	if opts.ContentType == "" {
+		opts.ContentType = archiveContentType
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pw.CloseWithError(writeTar(pw, localDir))
+	}()
+
+	result, err := c.UploadReaderWithOptions(ctx, bucket, objectName, pr, opts)
+	//Stop the walk if the upload failed before reading the whole tar.
+	pr.CloseWithError(fmt.Errorf("archive upload stopped"))
+	<-done
+	return result, err
+}
+
+//writeTar writes a tar of the tree under dir to w.
+func writeTar(w io.Writer, dir string) error {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		switch mode := info.Mode(); {
+		case mode&fs.ModeSymlink != 0:
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		case !mode.IsRegular() && !mode.IsDir():
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrLocalFile, err)
+		}
+		defer f.Close()
+		//A file that grew since it was stat'ed is cut at its header size
+		//rather than corrupting the archive.
+		_, err = io.Copy(tw, io.LimitReader(localFile{f}, hdr.Size))
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+//ExtractOpts configures an extraction.
+type ExtractOpts struct {
+	//DownloadOpts apply to the download of the archive.
+	DownloadOpts
+
+	//Overwrite replaces files that already exist in the destination. By
+	//default the extraction fails with an error wrapping fs.ErrExist on
+	//the first one. Existing directories are always reused.
+	Overwrite bool
+}
+
+//Extract downloads a tar archive, such as one written by Archive, from
+//objectName in GCS bucket and unpacks it into destDir, in the manner of
+//'tar -xz -C destDir'
+// - The archive is decompressed as in Download and unpacked as it
+//streams, with no intermediate file. destDir is created if needed.
+// - Directories, files and their modes and modification times are
+//recreated. Symbolic links are recreated if they point inside destDir.
+// - Entries with absolute paths or '..' components, and links leading
+//outside of destDir, are rejected; no file is written outside of it.
+// - The checksum can only be verified once the archive is read in full,
+//so on mismatch files have already been extracted and an error wrapping
+//ErrIntegrity is returned.
+func (c *Client) Extract(bucket string, objectName string, destDir string) error {
+	return c.ExtractWithOptions(c.ctx, bucket, objectName, destDir, ExtractOpts{})
+}
+
+//errExtractStopped stops the download of an archive whose extraction
+//failed.
+var errExtractStopped = errors.New("extraction stopped")
+
+//ExtractWithOptions is like Extract but runs under ctx and is configured
+//by opts.
+func (c *Client) ExtractWithOptions(ctx context.Context, bucket string, objectName string, destDir string, opts ExtractOpts) error {
+	if err := os.MkdirAll(destDir, 0o755); err != nil {
+		return err
+	}
+	root, err := os.OpenRoot(destDir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.download(ctx, bucket, objectName, pw, opts.DownloadOpts)
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	err = readTar(root, pr, opts.Overwrite)
+	if err == nil {
+		//Read any padding after the end of the archive so that the
+		//download verifies the checksum of the whole object.
+		_, err = io.Copy(io.Discard, pr)
+	}
+	pr.CloseWithError(errExtractStopped)
+	if derr := <-done; derr != nil && derr != errExtractStopped {
+		return derr
+	}
+	if err != nil {
+		c.log.Error("GCS: Error extracting archive", "object", objectName, "dir", destDir, "err", err)
+	}
+	return err
+}
+
+//extractedDir is a directory created by readTar, whose mode and
+//modification time are set once its entries are extracted.
+type extractedDir struct {
+	name    string
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+//readTar unpacks the tar read from r into root.
+//Directories are created writable by their owner only, so that a
+//read-only directory can be filled, and get their mode and modification
+//time after the last entry, children first, so that extracting their
+//entries does not change the time again.
+func readTar(root *os.Root, r io.Reader, overwrite bool) error {
+	var dirs []extractedDir
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return restoreDirs(root, dirs)
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid archive entry %q, must be a relative path within the archive", hdr.Name)
+		}
+		if dir := filepath.Dir(name); dir != "." {
+			if err := root.MkdirAll(dir, 0o755); err != nil {
+				return err
+			}
+		}
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := root.MkdirAll(name, 0o700); err != nil {
+				return err
+			}
+			dirs = append(dirs, extractedDir{name, mode.Perm(), hdr.ModTime})
+
+		case tar.TypeReg:
+			flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+			if overwrite {
+				flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+			}
+			f, err := root.OpenFile(name, flag, mode.Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			if err := root.Chmod(name, mode.Perm()); err != nil {
+				return err
+			}
+			if err := root.Chtimes(name, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			target := filepath.FromSlash(hdr.Linkname)
+			if filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), target)) {
+				return fmt.Errorf("invalid archive entry %q, links to %q outside of the archive", hdr.Name, hdr.Linkname)
+			}
+			if overwrite {
+				if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+			if err := root.Symlink(target, name); err != nil {
+				return err
+			}
+		}
+		//Hard links, devices and other special entries are skipped.
+	}
+}
+
+//restoreDirs sets the modes and modification times of dirs, in reverse
+//order so that children are done before their parents.
+func restoreDirs(root *os.Root, dirs []extractedDir) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		d := dirs[i]
+		if err := root.Chmod(d.name, d.mode); err != nil {
+			return err
+		}
+		if err := root.Chtimes(d.name, d.modTime, d.modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
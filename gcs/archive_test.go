@@ -0,0 +1,177 @@
+package gcs_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+)
+
+//tarEntry is an entry of a tar stream built by uploadTar.
+type tarEntry struct {
+	name string
+	link string
+	data string
+}
+
+//uploadTar uploads a tar of entries to 'archive.tar' in bucket, and
+//returns a client extracting from it.
+func uploadTar(t *testing.T, entries ...tarEntry) *gcs.Client {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Size: int64(len(e.data)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+	opts := gcs.UploadOpts{Compress: gcs.CompressAlways}
+	if _, err := client.UploadReaderWithOptions(context.Background(), "bucket", "archive.tar", &buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestExtractRejectsEscapingEntries(t *testing.T) {
+	tests := []tarEntry{
+		{name: "../x", data: "x"},
+		{name: "/etc/x", data: "x"},
+		{name: "dir/../../x", data: "x"},
+		{name: "link", link: "../x"},
+		{name: "link", link: "/etc/passwd"},
+	}
+	for _, e := range tests {
+		client := uploadTar(t, e)
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "dest")
+		err := client.ExtractWithOptions(context.Background(), "bucket", "archive.tar", dest, gcs.ExtractOpts{})
+		if err == nil || !strings.Contains(err.Error(), "invalid archive entry") {
+			t.Errorf("%q -> %q: Extract() = %v, want an invalid archive entry", e.name, e.link, err)
+		}
+		if _, err := os.Lstat(filepath.Join(parent, "x")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%q -> %q: wrote outside of the destination: %v", e.name, e.link, err)
+		}
+		if _, err := os.Lstat(filepath.Join(dest, "link")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%q -> %q: created the link: %v", e.name, e.link, err)
+		}
+	}
+}
+
+func TestExtractOverwrite(t *testing.T) {
+	client := uploadTar(t, tarEntry{name: "a.txt", data: "new"})
+	dest := t.TempDir()
+	p := filepath.Join(dest, "a.txt")
+	if err := os.WriteFile(p, []byte("old contents"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.ExtractWithOptions(context.Background(), "bucket", "archive.tar", dest, gcs.ExtractOpts{})
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Extract() = %v, want fs.ErrExist", err)
+	}
+	if data, _ := os.ReadFile(p); string(data) != "old contents" {
+		t.Errorf("a.txt = %q, want it unchanged", data)
+	}
+
+	opts := gcs.ExtractOpts{Overwrite: true}
+	if err := client.ExtractWithOptions(context.Background(), "bucket", "archive.tar", dest, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(p); string(data) != "new" {
+		t.Errorf("a.txt = %q, want %q", data, "new")
+	}
+}
+
+func TestArchiveExtract(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{"top.txt": "top", "ro/inner.txt": "inner", "ro/sub/deep.txt": "deep"}
+	for name, data := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("top.txt", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, dir := range []string{"ro/sub", "ro"} {
+		p := filepath.Join(src, dir)
+		if err := os.Chmod(p, 0o555); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dest := filepath.Join(t.TempDir(), "dest")
+	//Let the temporary directories be removed.
+	t.Cleanup(func() {
+		for _, dir := range []string{src, dest} {
+			os.Chmod(filepath.Join(dir, "ro"), 0o755)
+			os.Chmod(filepath.Join(dir, "ro", "sub"), 0o755)
+		}
+	})
+
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+	if err := client.Archive("bucket", "archive.tar.gz", src); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Extract("bucket", "archive.tar.gz", dest); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range files {
+		p := filepath.Join(dest, name)
+		data, err := os.ReadFile(p)
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+		if info, err := os.Stat(p); err == nil && info.Mode().Perm() != 0o640 {
+			t.Errorf("%s mode = %v, want %v", name, info.Mode().Perm(), fs.FileMode(0o640))
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dest, "link")); err != nil || link != "top.txt" {
+		t.Errorf("link = %q, %v, want %q", link, err, "top.txt")
+	}
+	for _, dir := range []string{"ro/sub", "ro"} {
+		info, err := os.Stat(filepath.Join(dest, dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o555 {
+			t.Errorf("%s mode = %v, want %v", dir, info.Mode().Perm(), fs.FileMode(0o555))
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s modified %v, want %v", dir, info.ModTime(), modTime)
+		}
+	}
+}
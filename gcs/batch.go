@@ -0,0 +1,94 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+//UploadAll uploads files to GCS bucket as Upload does, running up to
+//concurrency uploads at a time
+// - Returns one error per file, in the order of files; nil means success.
+func (c *Client) UploadAll(bucket string, files []string, concurrency int) []error {
+	return c.UploadAllWithOptions(c.ctx, bucket, files, concurrency, defaultUploadOpts)
+}
+
+//UploadAllWithOptions is like UploadAll but runs under ctx and uploads
+//each file with opts; opts.ObjectName is ignored and derived per file.
+//Once ctx is done, files not yet started are not uploaded and their
+//error is the context's error, so cancelling ctx stops a failing batch.
+//opts.Resume is not supported, since the files would share its session.
+func (c *Client) UploadAllWithOptions(ctx context.Context, bucket string, files []string, concurrency int, opts UploadOpts) []error {
+	opts.ObjectName = ""
+	errs := make([]error, len(files))
+	if opts.Resume != nil {
+		err := errors.New("Resume is not supported by batch uploads")
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	forEach(ctx, len(files), concurrency, func(i int) {
+		_, errs[i] = c.UploadWithOptions(ctx, bucket, files[i], opts)
+	}, func(i int, err error) {
+		errs[i] = err
+	})
+	return errs
+}
+
+//BatchDelete removes objectNames from GCS bucket, running up to
+//concurrency deletes at a time
+// - Returns one error per object, in the order of objectNames; nil means
+//success.
+// - Objects that do not exist count as deleted, so a batch can be rerun.
+func (c *Client) BatchDelete(bucket string, objectNames []string, concurrency int) []error {
+	return c.BatchDeleteContext(c.ctx, bucket, objectNames, concurrency)
+}
+
+//BatchDeleteContext is like BatchDelete but runs under ctx. Once ctx is
+//done, objects not yet started are not deleted and their error is the
+//context's error.
+func (c *Client) BatchDeleteContext(ctx context.Context, bucket string, objectNames []string, concurrency int) []error {
+	errs := make([]error, len(objectNames))
+	forEach(ctx, len(objectNames), concurrency, func(i int) {
+		err := c.deleteObject(ctx, bucket, objectNames[i])
+		if !errors.Is(err, ErrObjectNotFound) {
+			errs[i] = err
+		}
+	}, func(i int, err error) {
+		errs[i] = err
+	})
+	return errs
+}
+
+//forEach calls fn for each index in [0, n) with up to concurrency calls
+//running at once. Indexes not started when ctx is done are passed to
+//skip with the context's error instead.
+func forEach(ctx context.Context, n int, concurrency int, fn func(i int), skip func(i int, err error)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for i := 0; i < n; i++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			for ; i < n; i++ {
+				skip(i, err)
+			}
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,78 @@
+package gcs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+)
+
+func TestUploadAll(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+
+	files := make([]string, 20)
+	for i := range files {
+		files[i] = writeFile(t, fmt.Sprintf("file-%02d.txt", i), []byte(fmt.Sprint(i)))
+	}
+	files[7] = filepath.Join(t.TempDir(), "missing.txt")
+
+	errs := client.UploadAll("bucket", files, 4)
+	if len(errs) != len(files) {
+		t.Fatalf("%d errors, want %d", len(errs), len(files))
+	}
+	for i, err := range errs {
+		if i == 7 {
+			if !errors.Is(err, gcs.ErrLocalFile) {
+				t.Errorf("errs[7] = %v, want ErrLocalFile", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("errs[%d] = %v", i, err)
+		}
+		if _, ok := server.Object("bucket", filepath.Base(files[i])); !ok {
+			t.Errorf("%s not uploaded", files[i])
+		}
+	}
+}
+
+func TestUploadAllCancelled(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+
+	files := []string{writeFile(t, "a.txt", []byte("a")), writeFile(t, "b.txt", []byte("b"))}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i, err := range client.UploadAllWithOptions(ctx, "bucket", files, 2, gcs.UploadOpts{}) {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("errs[%d] = %v, want context.Canceled", i, err)
+		}
+	}
+	if names := server.ObjectNames("bucket"); len(names) != 0 {
+		t.Errorf("objects = %q, want none", names)
+	}
+}
+
+func TestUploadAllRejectsResume(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+
+	files := []string{writeFile(t, "a.txt", []byte("a")), writeFile(t, "b.txt", []byte("b"))}
+	opts := gcs.UploadOpts{Resume: &gcs.ResumeState{}}
+	for i, err := range client.UploadAllWithOptions(context.Background(), "bucket", files, 2, opts) {
+		if err == nil {
+			t.Errorf("errs[%d] = nil, want an error", i)
+		}
+	}
+	if names := server.ObjectNames("bucket"); len(names) != 0 {
+		t.Errorf("objects = %q, want none", names)
+	}
+}
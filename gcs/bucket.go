@@ -0,0 +1,379 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+//storageClasses are the storage classes accepted for objects and buckets.
+var storageClasses = map[string]bool{
+	"STANDARD": true,
+	"NEARLINE": true,
+	"COLDLINE": true,
+	"ARCHIVE":  true,
+}
+
+//validateStorageClass returns an error if class is neither empty nor a
+//known storage class.
+func validateStorageClass(class string) error {
+	if class != "" && !storageClasses[class] {
+		return fmt.Errorf("invalid storage class %q", class)
+	}
+	return nil
+}
+
+//kmsKeyName matches the resource name of a Cloud KMS key.
+var kmsKeyName = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+//validateKMSKeyName returns an error if name is neither empty nor the
+//resource name of a Cloud KMS key.
+func validateKMSKeyName(name string) error {
+	if name != "" && !kmsKeyName.MatchString(name) {
+		return fmt.Errorf("invalid KMS key name %q, want projects/P/locations/L/keyRings/R/cryptoKeys/K", name)
+	}
+	return nil
+}
+
+//validateEncryptionKey returns an error if key is neither empty nor an
+//AES-256 key.
+func validateEncryptionKey(key []byte) error {
+	if len(key) != 0 && len(key) != 32 {
+		return fmt.Errorf("invalid encryption key of %d bytes, want a 32 byte AES-256 key", len(key))
+	}
+	return nil
+}
+
+//chunkSizeMultiple is the granularity GCS requires of resumable upload
+//chunks.
+const chunkSizeMultiple = 256 << 10
+
+//ErrBucketNotFound is wrapped by operations on a bucket that does not
+//exist. The underlying storage.ErrBucketNotExist is wrapped as well.
+var ErrBucketNotFound = errors.New("bucket not found")
+
+//bucketNotFound wraps err, returned for the missing bucket, with
+//ErrBucketNotFound.
+func bucketNotFound(bucket string, err error) error {
+	return fmt.Errorf("%w: gs://%s: %w", ErrBucketNotFound, bucket, err)
+}
+
+//validateBucketName returns a descriptive error if name breaks the GCS
+//bucket naming rules, so that a bad name fails early rather than with a
+//400 from GCS:
+// - 3 to 63 characters, or up to 222 if it contains dots, with every
+//dot-separated component at most 63 characters.
+// - Only lowercase letters, digits, '-', '_' and '.'.
+// - Starts and ends with a letter or digit, and has no empty components,
+//i.e. no consecutive dots.
+// - Not an IP address in dotted-decimal form, and not starting with
+//'goog'.
+func validateBucketName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("invalid bucket name %q: %s", name, reason)
+	}
+
+	if len(name) < 3 || len(name) > 222 || len(name) > 63 && !strings.Contains(name, ".") {
+		return invalid("must be 3 to 63 characters, or up to 222 with dots")
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return invalid("must only contain lowercase letters, digits, '-', '_' and '.'")
+		}
+	}
+	if !isAlnum(name[0]) || !isAlnum(name[len(name)-1]) {
+		return invalid("must start and end with a letter or digit")
+	}
+	for _, part := range strings.Split(name, ".") {
+		if part == "" {
+			return invalid("must not contain consecutive dots")
+		}
+		if len(part) > 63 {
+			return invalid("dot-separated components must be at most 63 characters")
+		}
+	}
+	if net.ParseIP(name) != nil {
+		return invalid("must not be an IP address")
+	}
+	if strings.HasPrefix(name, "goog") {
+		return invalid("must not start with 'goog'")
+	}
+	return nil
+}
+
+//isAlnum reports whether b is a lowercase letter or a digit.
+func isAlnum(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= '0' && b <= '9'
+}
+
+//BucketOpts controls the creation of a bucket by an upload when the
+//bucket does not exist yet.
+type BucketOpts struct {
+	//CreateIfMissing creates the bucket in the client's project, with the
+	//attributes below, if it does not exist. It is off by default so that
+	//a mistyped bucket name fails instead of provisioning a new bucket.
+	//Creating a bucket requires the client to have a project ID. See
+	//WithBucketCreation to enable it for every upload of a client.
+	CreateIfMissing bool
+
+	//StorageClass is the default storage class of objects in the bucket,
+	//one of "STANDARD", "NEARLINE", "COLDLINE" or "ARCHIVE".
+	StorageClass string
+
+	//Location is the region, dual-region or multi-region the bucket is
+	//created in, such as "US-EAST1" or "EU". It defaults to "US".
+	Location string
+
+	//DefaultKMSKeyName is the Cloud KMS key objects in the bucket are
+	//encrypted with when no other key is given, in the form
+	//'projects/P/locations/L/keyRings/R/cryptoKeys/K'.
+	DefaultKMSKeyName string
+
+	//BucketSecurity hardens the access settings of the bucket. Buckets
+	//are created with uniform bucket-level access and public access
+	//prevention unless it relaxes them.
+	BucketSecurity
+}
+
+//BucketSecurity are the access settings of a bucket. The zero value is
+//the most restrictive.
+type BucketSecurity struct {
+	//FineGrainedACLs keeps per-object ACLs enabled, as UploadOpts.PublicRead
+	//requires. Otherwise uniform bucket-level access is enabled: access is
+	//granted through IAM on the bucket only.
+	FineGrainedACLs bool
+
+	//AllowPublicAccess lets objects be made readable by anyone. Otherwise
+	//public access prevention is enforced, so no IAM policy or ACL can
+	//grant access to allUsers or allAuthenticatedUsers.
+	AllowPublicAccess bool
+}
+
+//update returns the bucket update applying s.
+func (s BucketSecurity) update() storage.BucketAttrsToUpdate {
+	update := storage.BucketAttrsToUpdate{
+		UniformBucketLevelAccess: &storage.UniformBucketLevelAccess{Enabled: !s.FineGrainedACLs},
+		PublicAccessPrevention:   storage.PublicAccessPreventionEnforced,
+	}
+	if s.AllowPublicAccess {
+		update.PublicAccessPrevention = storage.PublicAccessPreventionInherited
+	}
+	return update
+}
+
+//validate reports an error for invalid attributes.
+func (o BucketOpts) validate() error {
+	if err := validateStorageClass(o.StorageClass); err != nil {
+		return err
+	}
+	return validateKMSKeyName(o.DefaultKMSKeyName)
+}
+
+//attrs returns the attributes to create the bucket with. Empty fields
+//keep the GCS defaults.
+func (o BucketOpts) attrs() *storage.BucketAttrs {
+	security := o.BucketSecurity.update()
+	attrs := &storage.BucketAttrs{
+		StorageClass:             o.StorageClass,
+		Location:                 o.Location,
+		UniformBucketLevelAccess: *security.UniformBucketLevelAccess,
+		PublicAccessPrevention:   security.PublicAccessPrevention,
+	}
+	if o.DefaultKMSKeyName != "" {
+		attrs.Encryption = &storage.BucketEncryption{DefaultKMSKeyName: o.DefaultKMSKeyName}
+	}
+	return attrs
+}
+
+//Bucket is the subset of bucket operations the upload path depends on.
+//Clients use the storage client for live buckets; WithBucketFactory
+//replaces it, e.g. with the in-memory fake of package gcsfake to test
+//upload logic without GCS. Implementations must be safe for concurrent
+//use and return the errors of the storage package, such as
+//storage.ErrBucketNotExist and storage.ErrObjectNotExist, so that they
+//are classified as for live buckets.
+type Bucket interface {
+	//BucketName returns the name of the bucket.
+	BucketName() string
+	//Attrs returns the attributes of the bucket.
+	Attrs(ctx context.Context) (*storage.BucketAttrs, error)
+	//Create creates the bucket in projectID with attrs.
+	Create(ctx context.Context, projectID string, attrs *storage.BucketAttrs) error
+	//NewWriter returns a writer creating the object described by req once
+	//closed.
+	NewWriter(ctx context.Context, req *WriteRequest) ObjectWriter
+	//ObjectAttrs returns the attributes of objectName, encrypted with the
+	//customer-supplied key if not empty.
+	ObjectAttrs(ctx context.Context, objectName string, key []byte) (*storage.ObjectAttrs, error)
+	//DeleteGeneration deletes objectName if its live generation is
+	//generation.
+	DeleteGeneration(ctx context.Context, objectName string, generation int64) error
+}
+
+//ObjectWriter is the subset of *storage.Writer the upload path uses.
+//Cancelling the context the writer was created with aborts the write.
+type ObjectWriter interface {
+	io.Writer
+	Close() error
+	Attrs() *storage.ObjectAttrs
+}
+
+//WriteRequest describes the object written by Bucket.NewWriter.
+type WriteRequest struct {
+	//Attrs are the attributes of the new object, including its name.
+	Attrs storage.ObjectAttrs
+	//SendCRC32C sends Attrs.CRC32C for GCS to validate the data against.
+	SendCRC32C bool
+	//Conditions are the preconditions of the write, if not nil.
+	Conditions *storage.Conditions
+	//ChunkSize is the writer's ChunkSize, or -1 to keep the default.
+	ChunkSize int
+	//EncryptionKey is the customer-supplied key of the object, if not
+	//empty.
+	EncryptionKey []byte
+	//Resume, if not nil, is the state of the resumable upload session the
+	//object is written through, updated as chunks are persisted. The whole
+	//object is written on every attempt; implementations without sessions
+	//may ignore it.
+	Resume *ResumeState
+	//Checkpoint is called with Resume as it is updated, if set.
+	Checkpoint func(ResumeState) error
+}
+
+//sdkBucket adapts *storage.BucketHandle to Bucket.
+type sdkBucket struct {
+	*storage.BucketHandle
+	//resumable sends the writes of requests with a resume state.
+	resumable *resumableTransport
+}
+
+//NewWriter returns a *storage.Writer for req, or a resumable upload
+//session writer if req.Resume is set.
+func (b sdkBucket) NewWriter(ctx context.Context, req *WriteRequest) ObjectWriter {
+	if req.Resume != nil {
+		return b.resumable.newWriter(ctx, b.BucketName(), req)
+	}
+	obj := b.Object(req.Attrs.Name)
+	if req.Conditions != nil {
+		obj = obj.If(*req.Conditions)
+	}
+	if len(req.EncryptionKey) != 0 {
+		obj = obj.Key(req.EncryptionKey)
+	}
+	wc := obj.NewWriter(ctx)
+	wc.ObjectAttrs = req.Attrs
+	wc.SendCRC32C = req.SendCRC32C
+	if req.ChunkSize >= 0 {
+		wc.ChunkSize = req.ChunkSize
+	}
+	return wc
+}
+
+//ObjectAttrs returns the attributes of objectName, encrypted with the
+//customer-supplied key if not empty.
+func (b sdkBucket) ObjectAttrs(ctx context.Context, objectName string, key []byte) (*storage.ObjectAttrs, error) {
+	obj := b.Object(objectName)
+	if len(key) != 0 {
+		obj = obj.Key(key)
+	}
+	return obj.Attrs(ctx)
+}
+
+//DeleteGeneration deletes objectName if its live generation is generation.
+func (b sdkBucket) DeleteGeneration(ctx context.Context, objectName string, generation int64) error {
+	return b.Object(objectName).If(storage.Conditions{GenerationMatch: generation}).Delete(ctx)
+}
+
+//BucketInfo describes a bucket.
+// - UniformBucketLevelAccess disables object ACLs, so that
+//UploadOpts.PublicRead fails, as does PublicAccessPrevention.
+// - RetentionPeriod is zero if the bucket has no retention policy.
+type BucketInfo struct {
+	Name                     string
+	Location                 string
+	LocationType             string
+	StorageClass             string
+	Versioning               bool
+	UniformBucketLevelAccess bool
+	PublicAccessPrevention   bool
+	RequesterPays            bool
+	RetentionPeriod          time.Duration
+	DefaultKMSKeyName        string
+	Labels                   map[string]string
+	Created                  time.Time
+}
+
+//newBucketInfo builds a BucketInfo from the attributes GCS returned for
+//a bucket.
+func newBucketInfo(attrs *storage.BucketAttrs) *BucketInfo {
+	info := &BucketInfo{
+		Name:                     attrs.Name,
+		Location:                 attrs.Location,
+		LocationType:             attrs.LocationType,
+		StorageClass:             attrs.StorageClass,
+		Versioning:               attrs.VersioningEnabled,
+		UniformBucketLevelAccess: attrs.UniformBucketLevelAccess.Enabled,
+		PublicAccessPrevention:   attrs.PublicAccessPrevention == storage.PublicAccessPreventionEnforced,
+		RequesterPays:            attrs.RequesterPays,
+		Labels:                   attrs.Labels,
+		Created:                  attrs.Created,
+	}
+	if attrs.RetentionPolicy != nil {
+		info.RetentionPeriod = attrs.RetentionPolicy.RetentionPeriod
+	}
+	if attrs.Encryption != nil {
+		info.DefaultKMSKeyName = attrs.Encryption.DefaultKMSKeyName
+	}
+	return info
+}
+
+//GetBucketInfo returns the attributes of a GCS bucket, such as its
+//location and whether it uses uniform bucket-level access.
+//Returns an error wrapping ErrBucketNotFound if the bucket does not exist.
+func (c *Client) GetBucketInfo(bucket string) (*BucketInfo, error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	attrs, err := c.storageBucket(bucket).Attrs(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error reading bucket attributes", "bucket", bucket, "err", err)
+		return nil, gcsError(bucket, "", err)
+	}
+	return newBucketInfo(attrs), nil
+}
+
+//UpdateBucketSecurity applies security to an existing GCS bucket, e.g.
+//UpdateBucketSecurity(bucket, BucketSecurity{}) to enforce uniform
+//bucket-level access and public access prevention
+// - Existing object ACLs stop applying once uniform bucket-level access
+//is enabled. It can only be disabled again within 90 days.
+// - Enforcing public access prevention revokes public access already
+//granted, such as that of objects uploaded with UploadOpts.PublicRead.
+func (c *Client) UpdateBucketSecurity(bucket string, security BucketSecurity) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	if c.skipDryRun("updating bucket security", "bucket", bucket,
+		"fineGrainedACLs", security.FineGrainedACLs, "allowPublicAccess", security.AllowPublicAccess) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	_, err := c.storageBucket(bucket).Update(ctx, security.update())
+	if err != nil {
+		c.log.Error("GCS: Error updating bucket security", "bucket", bucket, "err", err)
+		return gcsError(bucket, "", err)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package gcs
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"my-bucket", true},
+		{"my_bucket.logs", true},
+		{"abc", true},
+		{"0bucket9", true},
+		{"example.com", true},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 63) + "." + strings.Repeat("b", 63), true},
+		{strings.Repeat("a.", 110) + "a", true},
+
+		//Length.
+		{"", false},
+		{"ab", false},
+		{strings.Repeat("a", 64), false},
+		{strings.Repeat("a", 64) + ".b", false},
+		{strings.Repeat("a.", 111) + "a", false},
+
+		//Characters.
+		{"My-Bucket", false},
+		{"bucketA", false},
+		{"my bucket", false},
+		{"my/bucket", false},
+		{"bücket", false},
+
+		//Leading and trailing punctuation.
+		{"-bucket", false},
+		{"bucket-", false},
+		{"_bucket", false},
+		{".bucket", false},
+		{"bucket.", false},
+
+		//Consecutive dots.
+		{"my..bucket", false},
+		{"a...b", false},
+
+		//IP address form.
+		{"192.168.5.4", false},
+		{"10.0.0.1", false},
+		{"192.168.5", true},
+
+		//Reserved prefix.
+		{"goog-bucket", false},
+		{"google", false},
+		{"my-goog", true},
+	}
+	for _, tt := range tests {
+		err := validateBucketName(tt.name)
+		if tt.valid && err != nil {
+			t.Errorf("validateBucketName(%q) = %v, want nil", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("validateBucketName(%q) = nil, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package gcs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+//CompressMode selects whether an upload is compressed.
+type CompressMode int
+
+const (
+	//CompressNever stores data as is. It is the zero value.
+	CompressNever CompressMode = iota
+	//CompressAlways compresses all data.
+	CompressAlways
+	//CompressAuto compresses data unless its content type is already
+	//compressed, such as images, video or archives. Types that are neither
+	//known to be compressed nor text-like are compressed if a probe of
+	//their first 4KB compresses well.
+	CompressAuto
+)
+
+//validate returns an error for unknown modes.
+func (m CompressMode) validate() error {
+	if m < CompressNever || m > CompressAuto {
+		return fmt.Errorf("invalid compress mode %d", int(m))
+	}
+	return nil
+}
+
+//autoProbeSize is the amount of data CompressAuto test-compresses.
+const autoProbeSize = 4 << 10
+
+//autoProbeRatio is the compressed to original size ratio under which a
+//probe is considered to compress well.
+const autoProbeRatio = 0.9
+
+//compressedTypes are content types, besides image/*, video/* and
+//audio/*, whose data is already compressed.
+var compressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zip":              true,
+	"application/zstd":             true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+}
+
+//textTypes are content types, besides text/*, that compress well.
+var textTypes = map[string]bool{
+	"application/json":       true,
+	"application/xml":        true,
+	"application/javascript": true,
+	"application/x-ndjson":   true,
+	"application/sql":        true,
+	"image/svg+xml":          true,
+	"image/bmp":              true,
+}
+
+//autoCompress decides for CompressAuto whether data of contentType read
+//from r is compressed, returning CompressAlways or CompressNever, along
+//with a reader that yields the full data.
+func autoCompress(contentType string, r io.Reader) (CompressMode, io.Reader) {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case textTypes[mediaType] || strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml"):
+		return CompressAlways, r
+	case compressedTypes[mediaType] || strings.HasPrefix(mediaType, "image/") ||
+		strings.HasPrefix(mediaType, "video/") || strings.HasPrefix(mediaType, "audio/"):
+		return CompressNever, r
+	}
+
+	br := bufio.NewReaderSize(r, autoProbeSize)
+	head, _ := br.Peek(autoProbeSize)
+	if len(head) == 0 {
+		return CompressAlways, br
+	}
+	var probe countingWriter
+	zWriter := newGzipWriter(&probe, gzip.BestSpeed)
+	zWriter.Write(head)
+	zWriter.Close()
+	if float64(probe) < autoProbeRatio*float64(len(head)) {
+		return CompressAlways, br
+	}
+	return CompressNever, br
+}
+
+//countingWriter counts and discards the bytes written to it.
+type countingWriter int64
+
+//Write counts b.
+func (w *countingWriter) Write(b []byte) (int, error) {
+	*w += countingWriter(len(b))
+	return len(b), nil
+}
+
+//shorterThan reports whether r yields fewer than n bytes, reading at
+//most n of them, along with a reader that yields the full data.
+func shorterThan(r io.Reader, n int64) (bool, io.Reader, error) {
+	var head bytes.Buffer
+	_, err := io.CopyN(&head, r, n)
+	if err == io.EOF {
+		return true, &head, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return false, io.MultiReader(&head, r), nil
+}
+
+//Encoding is the compression applied to uploads that are compressed.
+type Encoding string
+
+const (
+	//EncodingGzip compresses with gzip. It is the default.
+	EncodingGzip Encoding = "gzip"
+	//EncodingZstd compresses with zstd, which is typically faster and
+	//smaller than gzip for large text such as logs.
+	EncodingZstd Encoding = "zstd"
+)
+
+//validate returns an error for unknown encodings.
+func (e Encoding) validate() error {
+	switch e {
+	case "", EncodingGzip, EncodingZstd:
+		return nil
+	}
+	return fmt.Errorf("invalid encoding %q", string(e))
+}
+
+//contentEncoding returns the content-encoding of objects compressed with e.
+func (e Encoding) contentEncoding() string {
+	if e == "" {
+		return string(EncodingGzip)
+	}
+	return string(e)
+}
+
+//compressedExts are the file extensions of gzip and zstd data.
+var compressedExts = []string{".gz", ".gzip", ".zst", ".zstd"}
+
+//precompressedContentType returns the content type of the decompressed
+//data of the compressed file name. See UploadOpts.AlreadyCompressed.
+func precompressedContentType(name string) string {
+	for _, ext := range compressedExts {
+		if base, ok := strings.CutSuffix(name, ext); ok {
+			if contentType := mime.TypeByExtension(path.Ext(base)); contentType != "" {
+				return contentType
+			}
+			break
+		}
+	}
+	return "application/octet-stream"
+}
+
+//precompressedEncoding returns the encoding of the compressed file name
+//from its extension, EncodingGzip unless it is '.zst' or '.zstd'. See
+//UploadOpts.AlreadyCompressed.
+func precompressedEncoding(name string) Encoding {
+	switch path.Ext(name) {
+	case ".zst", ".zstd":
+		return EncodingZstd
+	}
+	return EncodingGzip
+}
+
+//compressed reports whether uploads with o are compressed. CompressAuto
+//must have been resolved by autoCompress.
+func (o UploadOpts) compressed() bool {
+	return o.Compress == CompressAlways
+}
+
+//encode copies r to dst, compressing it if opts.compressed(), and returns
+//the number of bytes read from r. The compressed stream is closed before
+//returning.
+//The output is deterministic for given opts, so encodedCRC32C can compute
+//the checksum of an upload in a separate pass.
+func encode(dst io.Writer, r io.Reader, opts UploadOpts) (int64, error) {
+	if !opts.compressed() {
+		return io.Copy(dst, r)
+	}
+
+	zWriter, err := newCompressor(dst, opts)
+	if err != nil {
+		return 0, err
+	}
+	nBytes, err := io.Copy(zWriter, r)
+	if cerr := zWriter.Close(); err == nil {
+		err = cerr
+	}
+	return nBytes, err
+}
+
+//newCompressor returns a writer compressing to dst with opts.Encoding at
+//opts.CompressionLevel.
+func newCompressor(dst io.Writer, opts UploadOpts) (io.WriteCloser, error) {
+	if opts.Encoding == EncodingZstd {
+		level := zstd.SpeedDefault
+		if opts.CompressionLevel > 0 {
+			level = zstd.EncoderLevelFromZstd(opts.CompressionLevel)
+		}
+		return zstd.NewWriter(dst, zstd.WithEncoderLevel(level))
+	}
+	return newGzipWriter(dst, opts.CompressionLevel), nil
+}
+
+//gzipPools hold idle gzip writers, one pool per level from
+//gzip.HuffmanOnly to gzip.BestCompression, so that concurrent small
+//uploads reuse the compression window rather than allocate one each.
+var gzipPools [gzip.BestCompression - gzip.HuffmanOnly + 1]sync.Pool
+
+//pooledGzipWriter is a gzip writer that returns to its pool on Close.
+type pooledGzipWriter struct {
+	*gzip.Writer
+	pool *sync.Pool
+}
+
+//newGzipWriter returns a gzip writer compressing to dst at level, which
+//must be valid, taken from gzipPools if one is idle.
+func newGzipWriter(dst io.Writer, level int) pooledGzipWriter {
+	pool := &gzipPools[level-gzip.HuffmanOnly]
+	if zWriter, ok := pool.Get().(*gzip.Writer); ok {
+		zWriter.Reset(dst)
+		return pooledGzipWriter{zWriter, pool}
+	}
+	zWriter, _ := gzip.NewWriterLevel(dst, level)
+	return pooledGzipWriter{zWriter, pool}
+}
+
+//Close flushes the compressed stream and returns the writer to its pool.
+//The writer must not be used afterwards.
+func (w pooledGzipWriter) Close() error {
+	err := w.Writer.Close()
+	w.pool.Put(w.Writer)
+	return err
+}
+
+//newDecompressor returns a reader decoding r, stored with the
+//content-encoding contentEncoding, or nil if the encoding is not one
+//written by this package.
+func newDecompressor(r io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	switch Encoding(contentEncoding) {
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,91 @@
+package gcs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible data "), 1000)
+	tests := []struct {
+		encoding Encoding
+		level    int
+	}{
+		{EncodingGzip, gzip.BestSpeed},
+		{EncodingGzip, gzip.BestCompression},
+		{EncodingZstd, 0},
+		{EncodingZstd, 19},
+	}
+	for _, tt := range tests {
+		opts := UploadOpts{Compress: CompressAlways, Encoding: tt.encoding, CompressionLevel: tt.level}
+		var buf bytes.Buffer
+		if _, err := encode(&buf, bytes.NewReader(data), opts); err != nil {
+			t.Fatalf("%s level %d: %v", tt.encoding, tt.level, err)
+		}
+		if buf.Len() >= len(data) {
+			t.Errorf("%s level %d: compressed %d bytes to %d", tt.encoding, tt.level, len(data), buf.Len())
+		}
+		r, err := newDecompressor(&buf, opts.Encoding.contentEncoding())
+		if err != nil || r == nil {
+			t.Fatalf("%s: newDecompressor() = %v, %v", tt.encoding, r, err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s level %d: %v", tt.encoding, tt.level, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s level %d: round trip = %d bytes, want %d", tt.encoding, tt.level, len(got), len(data))
+		}
+	}
+}
+
+func TestNewDecompressorUnknownEncoding(t *testing.T) {
+	for _, encoding := range []string{"", "identity", "br"} {
+		r, err := newDecompressor(bytes.NewReader(nil), encoding)
+		if r != nil || err != nil {
+			t.Errorf("newDecompressor(%q) = %v, %v, want nil, nil", encoding, r, err)
+		}
+	}
+}
+
+//BenchmarkUploadGzip compresses small uploads concurrently as encode does,
+//with writers taken from gzipPools and with a new writer per upload.
+func BenchmarkUploadGzip(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 16<<10; i++ {
+		fmt.Fprintf(&buf, `{"id":%d,"name":"object-%d","size":%d}`+"\n", i, i, i*37)
+	}
+	data := buf.Bytes()
+	opts := UploadOpts{Compress: CompressAlways, CompressionLevel: gzip.DefaultCompression}
+
+	b.Run("pool", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				if _, err := encode(io.Discard, bytes.NewReader(data), opts); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+	b.Run("no-pool", func(b *testing.B) {
+		b.ReportAllocs()
+		b.SetBytes(int64(len(data)))
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				zWriter, _ := gzip.NewWriterLevel(io.Discard, opts.CompressionLevel)
+				if _, err := io.Copy(zWriter, bytes.NewReader(data)); err != nil {
+					b.Error(err)
+				}
+				if err := zWriter.Close(); err != nil {
+					b.Error(err)
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package gcs_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+)
+
+func TestConcurrentBucketCreation(t *testing.T) {
+	const n = 8
+	server := gcsfake.New()
+	//Hold every creation until all uploads have found the bucket missing,
+	//so that all but one of them race into a 409.
+	var found sync.WaitGroup
+	found.Add(n)
+	server.OnCreate = func(string) {
+		found.Done()
+		found.Wait()
+	}
+	client := newTestClient(t, server)
+
+	opts := gcs.UploadOpts{Bucket: gcs.BucketOpts{CreateIfMissing: true}}
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		file := writeFile(t, fmt.Sprintf("file-%d.txt", i), []byte("data"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = client.UploadWithOptions(context.Background(), "new-bucket", file, opts)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("upload %d: %v", i, err)
+		}
+	}
+	if names := server.ObjectNames("new-bucket"); len(names) != n {
+		t.Errorf("objects = %q, want %d", names, n)
+	}
+}
+
+//TestConcurrentUploadsToTwoBuckets uploads in parallel to two buckets
+//with one client; run with -race to check that the bucket of an upload
+//is not shared with the others.
+func TestConcurrentUploadsToTwoBuckets(t *testing.T) {
+	const n = 16
+	buckets := []string{"bucket-a", "bucket-b"}
+	server := gcsfake.New()
+	for _, bucket := range buckets {
+		server.CreateBucket(bucket)
+	}
+	client := newTestClient(t, server)
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		bucket := buckets[i%len(buckets)]
+		file := writeFile(t, fmt.Sprintf("%s-%d.txt", bucket, i), []byte(bucket))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = client.UploadWithOptions(context.Background(), bucket, file, gcs.UploadOpts{})
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("upload %d: %v", i, err)
+		}
+	}
+	for _, bucket := range buckets {
+		names := server.ObjectNames(bucket)
+		if len(names) != n/len(buckets) {
+			t.Errorf("objects of %s = %q, want %d", bucket, names, n/len(buckets))
+		}
+		for _, name := range names {
+			obj, _ := server.Object(bucket, name)
+			if !strings.HasPrefix(name, bucket+"-") || string(obj.Data) != bucket {
+				t.Errorf("object %q with data %q in %s, uploaded to another bucket", name, obj.Data, bucket)
+			}
+		}
+	}
+}
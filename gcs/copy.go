@@ -0,0 +1,212 @@
+package gcs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+//Copy copies srcObject in srcBucket to dstObject in dstBucket
+// - The copy is done server-side, so no data passes through the client.
+// - The content type, content-encoding and custom metadata of the source
+//are kept, so compressed objects stay compressed.
+// - dstObject is overwritten if it exists.
+// - Returns an error wrapping ErrObjectNotFound if srcObject does not exist.
+//Other failures name both objects and are categorized for the
+//destination, e.g. ErrPermission when dstBucket cannot be written.
+func (c *Client) Copy(srcBucket string, srcObject string, dstBucket string, dstObject string) error {
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	_, err := c.copy(ctx, srcBucket, srcObject, dstBucket, dstObject)
+	return err
+}
+
+//Move copies srcObject in srcBucket to dstObject in dstBucket as Copy
+//does, then deletes the source
+// - The source is only deleted if it was not replaced during the copy, so
+//a concurrent upload to srcObject is not lost.
+// - If the source cannot be deleted, the error names both objects: the
+//copy exists and the source is kept.
+func (c *Client) Move(srcBucket string, srcObject string, dstBucket string, dstObject string) error {
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	attrs, err := c.copy(ctx, srcBucket, srcObject, dstBucket, dstObject)
+	if err != nil {
+		return err
+	}
+
+	if c.skipDryRun("deleting moved object", "bucket", srcBucket, "object", srcObject) {
+		return nil
+	}
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	src := c.storageBucket(srcBucket).Object(srcObject)
+	err = src.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error deleting moved object", "object", srcObject, "err", err)
+		return fmt.Errorf("copied gs://%s/%s to gs://%s/%s but unable to delete the source: %w",
+			srcBucket, srcObject, dstBucket, dstObject, gcsError(srcBucket, srcObject, err))
+	}
+	return nil
+}
+
+//copy copies srcObject to dstObject and returns the attributes of the
+//source generation that was copied.
+func (c *Client) copy(ctx context.Context, srcBucket string, srcObject string, dstBucket string, dstObject string) (*storage.ObjectAttrs, error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	src := c.storageBucket(srcBucket).Object(srcObject)
+	attrs, err := src.Attrs(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error reading object attributes", "object", srcObject, "err", err)
+		return nil, gcsError(srcBucket, srcObject, err)
+	}
+
+	if c.skipDryRun("copying object", "object", srcObject, "dest", dstObject) {
+		return attrs, nil
+	}
+	dst := c.storageBucket(dstBucket).Object(dstObject)
+	_, err = dst.CopierFrom(src.Generation(attrs.Generation)).Run(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error copying object", "object", srcObject, "dest", dstObject, "err", err)
+		//The source was just read, so the copy fails on the destination.
+		return nil, fmt.Errorf("unable to copy gs://%s/%s to gs://%s/%s: %w",
+			srcBucket, srcObject, dstBucket, dstObject, gcsError(dstBucket, dstObject, err))
+	}
+	return attrs, nil
+}
+
+//maxComposeSources is the most source objects GCS accepts in one compose.
+const maxComposeSources = 32
+
+//ComposeOpts configures a compose.
+type ComposeOpts struct {
+	//ContentType is the content type of the composed object.
+	ContentType string
+
+	//ContentEncoding is the content-encoding of the composed object, such
+	//as 'gzip' when composing objects written by Upload: concatenated gzip
+	//or zstd streams decode as the concatenation of their contents.
+	ContentEncoding string
+}
+
+//Compose concatenates the objects sources, in order, into the object dest
+//of a GCS bucket
+// - The data is assembled server-side.
+// - More than 32 sources, the GCS limit of a single compose, are composed
+//as a tree through intermediate objects named after dest and a random
+//token, which are deleted afterwards. Only the generations this call
+//wrote are deleted, so existing objects are never touched.
+// - dest is overwritten if it exists.
+func (c *Client) Compose(bucket string, sources []string, dest string) error {
+	return c.ComposeWithOptions(c.ctx, bucket, sources, dest, ComposeOpts{})
+}
+
+//ComposeWithOptions is like Compose but runs under ctx and sets the
+//attributes in opts on dest.
+func (c *Client) ComposeWithOptions(ctx context.Context, bucket string, sources []string, dest string, opts ComposeOpts) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		return errors.New("Compose requires at least one source")
+	}
+
+	b := c.storageBucket(bucket)
+	var temps []tempObject
+	defer c.deleteTemps(context.WithoutCancel(ctx), b, &temps, "intermediate")
+
+	token := tempToken()
+	for level := 0; len(sources) > maxComposeSources; level++ {
+		var next []string
+		for i := 0; i < len(sources); i += maxComposeSources {
+			name := fmt.Sprintf("%s.compose-%s-%d-%d", dest, token, level, len(next))
+			//DoesNotExist keeps an object that happens to have the name.
+			attrs, err := c.compose(ctx, b, sources[i:min(i+maxComposeSources, len(sources))], name, storage.ObjectAttrs{}, &storage.Conditions{DoesNotExist: true})
+			if err != nil {
+				return err
+			}
+			if attrs != nil {
+				temps = append(temps, tempObject{name, attrs.Generation})
+			}
+			next = append(next, name)
+		}
+		sources = next
+	}
+
+	_, err := c.compose(ctx, b, sources, dest, storage.ObjectAttrs{
+		ContentType:     opts.ContentType,
+		ContentEncoding: opts.ContentEncoding,
+	}, nil)
+	return err
+}
+
+//compose runs a single compose of at most maxComposeSources sources into
+//dest with attrs, under conds if not nil, and returns the attributes of
+//dest, or nil in dry runs.
+func (c *Client) compose(ctx context.Context, b *storage.BucketHandle, sources []string, dest string, attrs storage.ObjectAttrs, conds *storage.Conditions) (*storage.ObjectAttrs, error) {
+	if c.skipDryRun("composing object", "bucket", b.BucketName(), "object", dest, "sources", len(sources)) {
+		return nil, nil
+	}
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	objs := make([]*storage.ObjectHandle, len(sources))
+	for i, name := range sources {
+		objs[i] = b.Object(name)
+	}
+
+	obj := b.Object(dest)
+	if conds != nil {
+		obj = obj.If(*conds)
+	}
+	composer := obj.ComposerFrom(objs...)
+	composer.ObjectAttrs = attrs
+	composed, err := composer.Run(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error composing object", "object", dest, "err", err)
+		return nil, gcsError(b.BucketName(), dest, err)
+	}
+	return composed, nil
+}
+
+//tempObject is a temporary object written by an operation, to be deleted
+//once it is done.
+type tempObject struct {
+	name       string
+	generation int64
+}
+
+//tempToken returns a random token for the names of the temporary objects
+//of an operation, so that they do not collide with the objects of other
+//operations on the same object.
+func tempToken() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+//deleteTemps deletes the temporary objects temps of b, described as kind
+//in logs, within the rate limit of c. Each is only deleted at the
+//generation that was written, so an object replaced since is kept.
+func (c *Client) deleteTemps(ctx context.Context, b *storage.BucketHandle, temps *[]tempObject, kind string) {
+	for _, t := range *temps {
+		if err := c.wait(ctx); err != nil {
+			c.log.Warn("GCS: Error deleting "+kind+" object", "object", t.name, "err", err)
+			return
+		}
+		err := b.Object(t.name).If(storage.Conditions{GenerationMatch: t.generation}).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			c.log.Warn("GCS: Error deleting "+kind+" object", "object", t.name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package gcs
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/api/option"
+)
+
+//ConnectWithCredentials initializes a Google Cloud Storage Client for
+//projectID authenticated with the credentials JSON credJSON, such as a
+//service account key held in memory
+// - If credJSON is empty, credentials are found as described for New.
+// - The returned client does not change the default client.
+func ConnectWithCredentials(projectID string, credJSON []byte) (*Client, error) {
+	return New(WithProjectID(projectID), WithCredentialsJSON(credJSON))
+}
+
+//ConnectWithCredentialsFile is like ConnectWithCredentials but reads the
+//credentials JSON from the file filename
+// - If filename is empty, credentials are found as described for New.
+func ConnectWithCredentialsFile(projectID string, filename string) (*Client, error) {
+	return New(WithProjectID(projectID), WithCredentialsFile(filename))
+}
+
+//credentialsOption returns the client option authenticating with the
+//credentials JSON credJSON, whose type is read from its 'type' field.
+func credentialsOption(credJSON []byte) (option.ClientOption, error) {
+	var creds struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(credJSON, &creds); err != nil {
+		return nil, fmt.Errorf("invalid credentials JSON: %w", err)
+	}
+	if creds.Type == "" {
+		return nil, fmt.Errorf("invalid credentials JSON: missing type")
+	}
+	return option.WithAuthCredentialsJSON(option.CredentialsType(creds.Type), credJSON), nil
+}
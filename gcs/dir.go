@@ -0,0 +1,222 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+//DirOpts configures a directory upload.
+type DirOpts struct {
+	//UploadOpts apply to every file. ObjectName is derived per file, or
+	//computed by NameFunc relative to the file's folder, and Prefix is
+	//replaced by the prefix of the directory upload. With
+	//CompressAuto the decision, and thus any name suffix, is per file.
+	UploadOpts
+
+	//FollowSymlinks uploads the targets of symbolic links, walking linked
+	//directories as if they were part of the tree. By default symbolic
+	//links are ignored.
+	FollowSymlinks bool
+
+	//ContinueOnError keeps uploading after a file fails; all failures are
+	//returned together. By default the upload stops at the first failure.
+	ContinueOnError bool
+
+	//Concurrency is the number of files uploaded at a time. It defaults
+	//to 1, uploading the files one after the other.
+	Concurrency int
+
+	//Progress, if set, is called with the progress of the whole tree as
+	//data is streamed and files finish, e.g. to render a single progress
+	//bar. The tree is scanned before uploading to compute the totals. Calls
+	//are serialized and should return quickly.
+	Progress func(DirProgress)
+}
+
+//DirProgress is the progress of a directory upload.
+// - Files count files finished, whether uploaded, skipped or failed.
+// - Bytes count the source bytes of each file up to the size it had when
+//the tree was scanned, and a finished file counts its scanned size, so
+//BytesDone reaches BytesTotal even if files changed size in between.
+type DirProgress struct {
+	FilesDone  int
+	FilesTotal int
+	BytesDone  int64
+	BytesTotal int64
+}
+
+//UploadDir uploads every file under localDir to GCS bucket
+// - The path of each file relative to localDir becomes its object name
+//under prefix, with '/' separators, so 'dir/a/b.json' is written to
+//'prefix/a/b.json'.
+// - Directories themselves, symbolic links and special files such as
+//sockets and devices are skipped.
+// - Stops at the first file that fails to upload.
+func (c *Client) UploadDir(bucket string, localDir string, prefix string) error {
+	return c.UploadDirWithOptions(c.ctx, bucket, localDir, prefix, DirOpts{UploadOpts: defaultUploadOpts})
+}
+
+//UploadDirWithOptions is like UploadDir but runs under ctx and is
+//configured by opts. Errors name the file they occurred for.
+func (c *Client) UploadDirWithOptions(ctx context.Context, bucket string, localDir string, prefix string, opts DirOpts) error {
+	return c.uploadDir(ctx, bucket, localDir, prefix, opts, func(*UploadResult) {})
+}
+
+//dirFile is a file found under the directory of a directory upload.
+type dirFile struct {
+	//path is the path of the file to upload.
+	path string
+	//key is the path relative to the directory, with '/' separators.
+	key string
+	//size is the size of the file when it was found.
+	size int64
+}
+
+//uploadDir is UploadDirWithOptions calling uploaded with the result of
+//each file uploaded or skipped. Calls to uploaded are serialized.
+func (c *Client) uploadDir(ctx context.Context, bucket string, localDir string, prefix string, opts DirOpts, uploaded func(*UploadResult)) error {
+	if opts.Resume != nil {
+		return errors.New("Resume is not supported by directory uploads")
+	}
+	var errs []error
+	fail := func(p string, err error) error {
+		err = fmt.Errorf("%s: %w", p, err)
+		if !opts.ContinueOnError {
+			return err
+		}
+		errs = append(errs, err)
+		return nil
+	}
+
+	files, err := scanDir(ctx, localDir, opts, fail)
+	if err != nil {
+		return err
+	}
+
+	progress := newDirProgress(files, opts.Progress)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var firstErr error
+	fileErrs := make([]error, len(files))
+	forEach(ctx, len(files), opts.Concurrency, func(i int) {
+		file := files[i]
+		fileOpts := opts.UploadOpts
+		fileOpts.Prefix = prefix
+		if dir := path.Dir(file.key); dir != "." {
+			fileOpts.Prefix = path.Join(prefix, dir)
+		}
+		fileOpts.ObjectName = ""
+		fileOpts.Progress = progress.file(i, fileOpts.Progress)
+
+		result, err := c.UploadWithOptions(ctx, bucket, file.path, fileOpts)
+		progress.done(i)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			fileErrs[i] = fmt.Errorf("%s: %w", file.path, err)
+			if firstErr == nil && !opts.ContinueOnError {
+				//Stop the uploads in flight; their errors are not reported.
+				firstErr = fileErrs[i]
+				cancel()
+			}
+			return
+		}
+		uploaded(result)
+	}, func(i int, err error) {
+		fileErrs[i] = err
+	})
+
+	if firstErr != nil {
+		return firstErr
+	}
+	for _, err := range fileErrs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+//scanDir returns the files to upload under localDir, in lexical order.
+//Errors are passed to fail with the path they occurred for, and the scan
+//stops if fail returns an error.
+func scanDir(ctx context.Context, localDir string, opts DirOpts, fail func(p string, err error) error) ([]dirFile, error) {
+	root, err := filepath.EvalSymlinks(localDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []dirFile
+
+	//visited holds the directories walked so far, so that symbolic links
+	//to an enclosing directory do not loop forever.
+	visited := map[string]bool{}
+
+	var walk func(dir string, base string) error
+	walk = func(dir string, base string) error {
+		return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return fail(p, err)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return fail(p, err)
+			}
+			key := path.Join(base, filepath.ToSlash(rel))
+
+			var info fs.FileInfo
+			if d.Type()&fs.ModeSymlink != 0 {
+				if !opts.FollowSymlinks {
+					return nil
+				}
+				target, err := filepath.EvalSymlinks(p)
+				if err != nil {
+					return fail(p, err)
+				}
+				info, err = os.Stat(target)
+				if err != nil {
+					return fail(p, err)
+				}
+				if info.IsDir() {
+					if visited[target] {
+						return nil
+					}
+					return walk(target, key)
+				}
+			} else {
+				info, err = d.Info()
+				if err != nil {
+					return fail(p, err)
+				}
+			}
+			if info.IsDir() {
+				visited[p] = true
+				return nil
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			files = append(files, dirFile{path: p, key: key, size: info.Size()})
+			return nil
+		})
+	}
+
+	if err := walk(root, ""); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
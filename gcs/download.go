@@ -0,0 +1,195 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+//DownloadOpts configures a download.
+type DownloadOpts struct {
+	//EncryptionKey is the customer-supplied AES-256 key the object was
+	//uploaded with, if any.
+	EncryptionKey []byte
+
+	//Generation selects a generation of the object in a bucket with
+	//versioning, such as a noncurrent version listed by ListVersions.
+	//Zero reads the live object.
+	Generation int64
+
+	//SkipVerify returns the data even if its checksum does not match the
+	//CRC32C GCS stored, e.g. to inspect a corrupted object. By default
+	//such downloads fail with an error wrapping ErrIntegrity.
+	SkipVerify bool
+}
+
+//validate reports an error for invalid option values.
+func (o DownloadOpts) validate() error {
+	if o.Generation < 0 {
+		return fmt.Errorf("invalid generation %d", o.Generation)
+	}
+	return validateEncryptionKey(o.EncryptionKey)
+}
+
+//Download reads an object from a GCS bucket and returns its contents
+// - Objects stored with content-encoding 'gzip' or 'zstd' (as written by
+//Upload) are transparently decompressed.
+// - The data is verified against the CRC32C GCS stored, which unlike MD5
+//exists for every object including composed ones; a mismatch returns an
+//error wrapping ErrIntegrity.
+// - Returns an error wrapping ErrObjectNotFound if the object does not
+//exist, and ErrPermission if access is denied.
+func (c *Client) Download(bucket string, objectName string) ([]byte, error) {
+	return c.DownloadWithOptions(c.ctx, bucket, objectName, DownloadOpts{})
+}
+
+//DownloadWithOptions is like Download but runs under ctx and is
+//configured by opts.
+func (c *Client) DownloadWithOptions(ctx context.Context, bucket string, objectName string, opts DownloadOpts) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := c.download(ctx, bucket, objectName, &buf, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+//DownloadTo streams an object from a GCS bucket into w, such as an
+//http.ResponseWriter, and returns the number of bytes written
+// - The object is decompressed as in Download without being buffered in
+//memory.
+// - The checksum can only be verified once the data is read in full, so on
+//mismatch w has already received it and an error wrapping ErrIntegrity
+//is returned.
+func (c *Client) DownloadTo(bucket string, objectName string, w io.Writer) (int64, error) {
+	return c.DownloadToWithOptions(c.ctx, bucket, objectName, w, DownloadOpts{})
+}
+
+//DownloadToWithOptions is like DownloadTo but runs under ctx and is
+//configured by opts.
+func (c *Client) DownloadToWithOptions(ctx context.Context, bucket string, objectName string, w io.Writer, opts DownloadOpts) (int64, error) {
+	return c.download(ctx, bucket, objectName, w, opts)
+}
+
+//DownloadGeneration reads the given generation of an object from a GCS
+//bucket with versioning, decompressing it as in Download.
+func (c *Client) DownloadGeneration(bucket string, objectName string, generation int64) ([]byte, error) {
+	return c.DownloadWithOptions(c.ctx, bucket, objectName, DownloadOpts{Generation: generation})
+}
+
+//DownloadToFile reads an object from a GCS bucket into the file dest
+// - The object is decompressed as in Download and streamed to disk.
+// - dest is created or truncated; it is removed if the download fails.
+func (c *Client) DownloadToFile(bucket string, objectName string, dest string) error {
+	return c.DownloadToFileWithOptions(c.ctx, bucket, objectName, dest, DownloadOpts{})
+}
+
+//DownloadToFileWithOptions is like DownloadToFile but runs under ctx and
+//is configured by opts.
+func (c *Client) DownloadToFileWithOptions(ctx context.Context, bucket string, objectName string, dest string, opts DownloadOpts) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		c.log.Error("GCS: Error creating file for download", "file", dest, "err", err)
+		return err
+	}
+
+	_, err = c.download(ctx, bucket, objectName, f, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return err
+	}
+	return nil
+}
+
+//DownloadToDir reads an object from a GCS bucket into the directory dir,
+//restoring the name of the file it was uploaded from
+// - The file is named after the OriginalFilenameKey metadata of the
+//object, or else the base of objectName, and its path is returned.
+// - The object is decompressed and the file replaced as in DownloadToFile.
+func (c *Client) DownloadToDir(bucket string, objectName string, dir string) (string, error) {
+	name, err := c.OriginalFilename(bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	//The name comes from metadata anyone with write access may set, so it
+	//must not lead outside of dir.
+	name = filepath.Base(filepath.FromSlash(name))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		name = path.Base(objectName)
+	}
+
+	dest := filepath.Join(dir, name)
+	if err := c.DownloadToFile(bucket, objectName, dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+//download copies the decoded contents of objectName to w and returns the
+//number of bytes written. Data is verified against the stored CRC32C
+//once read in full, so on mismatch w has already received it.
+//The stored bytes are requested as is and decompressed here, so the
+//result does not depend on server-side transcoding.
+func (c *Client) download(ctx context.Context, bucket string, objectName string, w io.Writer, opts DownloadOpts) (int64, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	if err := c.checkOpen(); err != nil {
+		return 0, err
+	}
+	if err := opts.validate(); err != nil {
+		return 0, err
+	}
+
+	obj := c.storageBucket(bucket).Object(objectName).ReadCompressed(true)
+	if len(opts.EncryptionKey) != 0 {
+		obj = obj.Key(opts.EncryptionKey)
+	}
+	if opts.Generation != 0 {
+		obj = obj.Generation(opts.Generation)
+	}
+	rc, err := obj.NewReader(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error opening object for download", "object", objectName, "err", err)
+		return 0, gcsError(bucket, objectName, err)
+	}
+	defer rc.Close()
+
+	//The CRC32C GCS stored is that of the stored, possibly compressed,
+	//bytes, so they are checksummed before decompression.
+	h := crc32.New(crc32cTable)
+	stored := io.TeeReader(rc, h)
+
+	var src io.Reader = stored
+	zReader, err := newDecompressor(stored, rc.Attrs.ContentEncoding)
+	if err != nil {
+		c.log.Error("GCS: Error decompressing object", "object", objectName, "err", err)
+		return 0, err
+	}
+	if zReader != nil {
+		defer zReader.Close()
+		src = zReader
+	}
+
+	nBytes, err := io.Copy(w, src)
+	if err == nil {
+		//Checksum any bytes the decompressor left unread past the end of
+		//its stream.
+		_, err = io.Copy(io.Discard, stored)
+	}
+	if err != nil {
+		c.log.Error("GCS: Error reading object", "object", objectName, "err", err)
+		return nBytes, err
+	}
+	if !opts.SkipVerify && h.Sum32() != rc.Attrs.CRC32C {
+		c.log.Error("GCS: Checksum mismatch on download", "object", objectName)
+		return nBytes, fmt.Errorf("%w: gs://%s/%s read crc32c %08x, stored %08x", ErrIntegrity, bucket, objectName, h.Sum32(), rc.Attrs.CRC32C)
+	}
+	return nBytes, nil
+}
@@ -0,0 +1,83 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"log/slog"
+
+	"cloud.google.com/go/storage"
+)
+
+//dryRunBucket is the Bucket of clients created with WithDryRun.
+//Reads go to GCS; writes, bucket creation and deletes are logged instead
+//of performed.
+type dryRunBucket struct {
+	Bucket
+	log *slog.Logger
+}
+
+//Create logs the bucket that would be created.
+func (b dryRunBucket) Create(ctx context.Context, projectID string, attrs *storage.BucketAttrs) error {
+	b.log.Info("GCS: Dry run, not creating bucket", "bucket", b.BucketName(), "project", projectID)
+	return nil
+}
+
+//NewWriter returns a writer that discards the data.
+func (b dryRunBucket) NewWriter(ctx context.Context, req *WriteRequest) ObjectWriter {
+	attrs := req.Attrs
+	attrs.Bucket = b.BucketName()
+	return &dryRunWriter{attrs: attrs, h: crc32.New(crc32cTable), log: b.log}
+}
+
+//ObjectAttrs returns the attributes of objectName. A bucket that does not
+//exist, since its creation was skipped, holds no objects.
+func (b dryRunBucket) ObjectAttrs(ctx context.Context, objectName string, key []byte) (*storage.ObjectAttrs, error) {
+	attrs, err := b.Bucket.ObjectAttrs(ctx, objectName, key)
+	if errors.Is(err, storage.ErrBucketNotExist) {
+		return nil, storage.ErrObjectNotExist
+	}
+	return attrs, err
+}
+
+//DeleteGeneration logs the object that would be deleted.
+func (b dryRunBucket) DeleteGeneration(ctx context.Context, objectName string, generation int64) error {
+	b.log.Info("GCS: Dry run, not deleting object", "bucket", b.BucketName(), "object", objectName)
+	return nil
+}
+
+//dryRunWriter checksums and discards the data of an object, and reports
+//the attributes the object would have had.
+type dryRunWriter struct {
+	attrs storage.ObjectAttrs
+	h     hash.Hash32
+	log   *slog.Logger
+}
+
+//Write checksums and discards b.
+func (w *dryRunWriter) Write(b []byte) (int, error) {
+	w.attrs.Size += int64(len(b))
+	return w.h.Write(b)
+}
+
+//Close logs the object that would have been written.
+func (w *dryRunWriter) Close() error {
+	w.attrs.CRC32C = w.h.Sum32()
+	w.log.Info("GCS: Dry run, not writing object", "bucket", w.attrs.Bucket, "object", w.attrs.Name, "bytes", w.attrs.Size)
+	return nil
+}
+
+//Attrs returns the attributes the object would have had.
+func (w *dryRunWriter) Attrs() *storage.ObjectAttrs {
+	return &w.attrs
+}
+
+//skipDryRun reports whether c is a dry-run client, in which case it logs
+//that action, described by msg and attrs, is skipped.
+func (c *Client) skipDryRun(msg string, attrs ...any) bool {
+	if c.dryRun {
+		c.log.Info("GCS: Dry run, not "+msg, attrs...)
+	}
+	return c.dryRun
+}
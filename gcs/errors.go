@@ -0,0 +1,79 @@
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+//ErrPermission is wrapped by operations GCS refused because the
+//credentials lack a permission or are invalid (HTTP 401 and 403).
+var ErrPermission = errors.New("permission denied")
+
+//ErrLocalFile is wrapped by uploads that failed to open or read the
+//local file, as opposed to failing to write to GCS. The cause is wrapped
+//as well, so that e.g. batch uploads can skip missing files with
+//errors.Is(err, fs.ErrNotExist) but abort on fs.ErrPermission. Uploading
+//a directory fails with ErrLocalFile and syscall.EISDIR before anything
+//is written.
+var ErrLocalFile = errors.New("local file error")
+
+//ErrHMACNotSupported describes why HMAC keys cannot authenticate a
+//Client: they only sign requests to the S3-compatible XML API, while the
+//storage client uses the JSON and gRPC APIs. Programs configured with
+//HMAC keys only can fail with it rather than with missing credentials.
+var ErrHMACNotSupported = errors.New("HMAC keys are not supported by the JSON client, use a service account key or impersonation")
+
+//gcsError wraps err, returned by GCS for objectName in bucket, with the
+//sentinel error of its category, so callers can branch with errors.Is:
+// - ErrObjectNotFound and ErrBucketNotFound for missing resources.
+// - ErrPermission for 401 and 403 responses.
+//Other errors are returned unchanged. objectName is empty for bucket
+//operations.
+func gcsError(bucket string, objectName string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, storage.ErrObjectNotExist):
+		return objectNotFound(bucket, objectName, err)
+	case errors.Is(err, storage.ErrBucketNotExist):
+		return bucketNotFound(bucket, err)
+	}
+
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && (gerr.Code == http.StatusUnauthorized || gerr.Code == http.StatusForbidden) {
+		if objectName == "" {
+			return fmt.Errorf("%w: gs://%s: %w", ErrPermission, bucket, err)
+		}
+		return fmt.Errorf("%w: gs://%s/%s: %w", ErrPermission, bucket, objectName, err)
+	}
+	return err
+}
+
+//localFile wraps the errors of reading and seeking a file being uploaded,
+//or a section of it, with ErrLocalFile.
+type localFile struct {
+	f io.ReadSeeker
+}
+
+//Read reads from the file.
+func (l localFile) Read(b []byte) (int, error) {
+	n, err := l.f.Read(b)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %w", ErrLocalFile, err)
+	}
+	return n, err
+}
+
+//Seek sets the offset of the next Read.
+func (l localFile) Seek(offset int64, whence int) (int64, error) {
+	n, err := l.f.Seek(offset, whence)
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrLocalFile, err)
+	}
+	return n, err
+}
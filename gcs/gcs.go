@@ -1,133 +1,1317 @@
 //Package gcs presents a simple API for uploading files to Google Cloud Storage.
-//Two methods are exposed: Connect and Upload:
+//The main methods exposed are Connect, Upload and UploadReader:
 // - Connect sets up the connection to GCS and ensures that credentials and
-//identification information is correctly set.
+//identification information is correctly set. It returns an error rather
+//than exiting so that callers can decide how to handle failure.
 // - Upload compresses and writes file to a GCS bucket.
+// - UploadReader compresses and streams an io.Reader to a GCS bucket.
+// - Download reads an object back, decompressing it if needed.
+//
+//Each call to Connect returns an independent *Client, so a process may talk
+//to several projects at once. The package-level Upload and UploadReader
+//functions use the client returned by the most recent Connect.
+//
+//Clients do not print anything; diagnostics can be routed to a
+//*slog.Logger with Client.SetLogger.
 package gcs
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"mime"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/option"
 )
 
-type gcsClient struct {
-	projectID  string
-	bucketName string
-	bucket     *storage.BucketHandle
-	client     *storage.Client
-	ctx        context.Context
+//Client is a connection to Google Cloud Storage for a single project.
+//It is safe for concurrent use by multiple goroutines, including uploads
+//to different buckets.
+type Client struct {
+	projectID string
+	client    *storage.Client
+	ctx       context.Context
+	log       *slog.Logger
+
+	//ownsClient is set unless client was adopted with WithStorageClient,
+	//and c.Close then closes it.
+	ownsClient bool
+
+	//compressionLevel is the gzip level of uploads that do not set one.
+	compressionLevel int
+
+	//newBucket opens the named bucket for the upload path; see
+	//WithBucketFactory.
+	newBucket func(name string) Bucket
+
+	//dryRun logs mutations instead of performing them; see WithDryRun.
+	dryRun bool
+
+	//opTimeout bounds each operation if positive; see WithOperationTimeout.
+	opTimeout time.Duration
+
+	//limiter paces uploads and deletes if not nil; see WithRateLimit.
+	limiter *rate.Limiter
+
+	//bandwidth caps the bytes per second uploaded if not nil, one token
+	//per byte; see WithBandwidthLimit.
+	bandwidth *rate.Limiter
+
+	//hooks observe the uploads of the client; see WithHooks.
+	hooks Hooks
+
+	//userProject is billed for requests if not empty; see WithUserProject.
+	userProject string
+
+	//createBuckets creates missing buckets on upload; see
+	//WithBucketCreation.
+	createBuckets bool
+
+	//retryDeadline is the default RetryPolicy.Deadline; see
+	//WithRetryDeadline.
+	retryDeadline time.Duration
+
+	//compressedSuffix is the suffix of uploads that do not set one; see
+	//WithCompressedSuffix.
+	compressedSuffix string
+
+	//resumable sends the uploads of UploadOpts.Resume, or is nil if the
+	//storage client was adopted.
+	resumable *resumableTransport
+
+	closed atomic.Bool
 }
 
-var singleton *gcsClient
-var once sync.Once
+//defaultClient is the client used by the package-level functions.
+var defaultClient *Client
+var defaultMu sync.RWMutex
+
+//ErrMissingEnv is wrapped by errors due to a required environment
+//variable being empty, such as creating a bucket without a project.
+var ErrMissingEnv = errors.New("required environment variable is empty")
+
+//ErrClosed is returned by operations on a Client after Close.
+var ErrClosed = errors.New("GCS client is closed")
+
+//ErrPreconditionFailed is wrapped by uploads whose DoesNotExist or
+//GenerationMatch precondition did not hold (HTTP 412).
+var ErrPreconditionFailed = errors.New("object precondition failed")
+
+//ErrNotConnected is returned by the package-level functions when Connect
+//has not been called successfully.
+var ErrNotConnected = errors.New("not connected to GCS")
 
 //Connect initializes the Google Cloud Storage Client:
-// - Credentials and projectID must be set as environment vars per GCS documentation
-// - Creates new client based on these settings
-// - Program exits if any of above checks fails.
-func Connect() {
-	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	if projectID == "" {
-		fmt.Fprintln(os.Stderr, "GOOGLE_CLOUD_PROJECT environment variable must be set.")
-		os.Exit(1)
+// - projectID is set by WithProjectID or environment var GOOGLE_CLOUD_PROJECT;
+//it is only required to create buckets
+// - Credentials are found as described for New
+// - Creates new client based on these settings and opts
+// - Returns an error if any of above checks fails.
+//The returned client also becomes the default client used by the
+//package-level Upload and UploadReader functions.
+func Connect(opts ...Option) (*Client, error) {
+	gcs, err := New(opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	gcsCredentials := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if gcsCredentials == "" {
-		fmt.Fprintln(os.Stderr, "GOOGLE_APPLICATION_CREDENTIALS environment variable must be set.")
-		os.Exit(1)
+	defaultMu.Lock()
+	defaultClient = gcs
+	defaultMu.Unlock()
+	return gcs, nil
+}
+
+//New initializes a Google Cloud Storage Client configured by opts:
+// - projectID is set by WithProjectID or environment var GOOGLE_CLOUD_PROJECT.
+//It is optional: reading and writing existing buckets do not need it, and
+//creating a bucket without it fails with an error wrapping ErrMissingEnv.
+// - Credentials are set by WithCredentialsJSON or WithCredentialsFile, or
+//else found as Application Default Credentials: from the file named by
+//GOOGLE_APPLICATION_CREDENTIALS if set, otherwise e.g. from gcloud or the
+//metadata server on GCE, GKE and Cloud Run.
+// - HMAC keys cannot authenticate a client: they only sign requests to
+//the S3-compatible XML API, while the storage client uses the JSON and
+//gRPC APIs, which require OAuth2 credentials; see ErrHMACNotSupported.
+//Environments provisioned with HMAC keys only need a service account key
+//or impersonation instead.
+// - Returns an error if no credentials can be found.
+// - If STORAGE_EMULATOR_HOST is set, the client talks to that emulator
+//without authentication and no credentials are required.
+// - WithStorageClient adopts an existing *storage.Client instead, whose
+//credentials are used as is.
+// - The returned client is independent of any other client and does not
+//change the default client.
+func New(opts ...Option) (*Client, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if err := errors.Join(cfg.errs...); err != nil {
+		return nil, err
+	}
+
+	if cfg.projectID == "" {
+		cfg.projectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if cfg.compressionLevel < gzip.HuffmanOnly || cfg.compressionLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid gzip compression level %d", cfg.compressionLevel)
+	}
+
+	client := cfg.client
+	var resumable *resumableTransport
+	if client == nil {
+		var clientOpts []option.ClientOption
+		var err error
+		if client, clientOpts, err = newStorageClient(cfg); err != nil {
+			return nil, err
+		}
+		resumable = newResumableTransport(cfg, clientOpts)
+	} else if len(cfg.credentialsJSON) > 0 || cfg.credentialsFile != "" || cfg.impersonate != "" || cfg.endpoint != "" {
+		return nil, errors.New("WithStorageClient cannot be combined with credentials, impersonation or endpoint options")
+	}
+
+	gcs := &Client{
+		projectID:        cfg.projectID,
+		ctx:              cfg.ctx,
+		log:              cfg.logger,
+		compressionLevel: cfg.compressionLevel,
+		dryRun:           cfg.dryRun,
+		opTimeout:        cfg.opTimeout,
+		hooks:            cfg.hooks,
+		userProject:      cfg.userProject,
+		createBuckets:    cfg.createBuckets,
+		retryDeadline:    cfg.retryDeadline,
+		compressedSuffix: cfg.compressedSuffix,
+		client:           client,
+		ownsClient:       cfg.client == nil,
+		resumable:        resumable,
+	}
+	if cfg.rateLimit > 0 {
+		gcs.limiter = rate.NewLimiter(cfg.rateLimit, cfg.rateBurst)
+	}
+	if cfg.bandwidth > 0 {
+		//A burst of at most 256KiB keeps the rate smooth at high limits
+		//and a burst of a second's worth caps it at low ones.
+		gcs.bandwidth = rate.NewLimiter(rate.Limit(cfg.bandwidth), min(cfg.bandwidth, 256<<10))
+	}
+	gcs.newBucket = func(name string) Bucket {
+		return sdkBucket{gcs.storageBucket(name), gcs.resumable}
+	}
+	if cfg.bucketFactory != nil {
+		gcs.newBucket = cfg.bucketFactory
+	}
+	if gcs.dryRun {
+		newBucket := gcs.newBucket
+		gcs.newBucket = func(name string) Bucket {
+			return dryRunBucket{newBucket(name), gcs.log}
+		}
+	}
+	return gcs, nil
+}
+
+//newStorageClient creates the storage client of New from the credentials
+//and endpoint of cfg, and returns the options it was created with.
+func newStorageClient(cfg config) (*storage.Client, []option.ClientOption, error) {
+	var clientOpts []option.ClientOption
+	switch {
+	case len(cfg.credentialsJSON) > 0:
+		opt, err := credentialsOption(cfg.credentialsJSON)
+		if err != nil {
+			return nil, nil, err
+		}
+		clientOpts = append(clientOpts, opt)
+	case cfg.credentialsFile != "":
+		data, err := os.ReadFile(cfg.credentialsFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read credentials file: %w", err)
+		}
+		opt, err := credentialsOption(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		clientOpts = append(clientOpts, opt)
+	case os.Getenv("STORAGE_EMULATOR_HOST") == "":
+		_, err := google.FindDefaultCredentials(cfg.ctx, storage.ScopeFullControl)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to find GCS credentials: %w", err)
+		}
+	}
+	if cfg.impersonate != "" {
+		scopes := cfg.impersonateScopes
+		if len(scopes) == 0 {
+			scopes = []string{storage.ScopeFullControl}
+		}
+		//The credentials found above authenticate the impersonation; the
+		//client itself only uses the short-lived impersonated tokens.
+		ts, err := impersonate.CredentialsTokenSource(cfg.ctx, impersonate.CredentialsConfig{
+			TargetPrincipal: cfg.impersonate,
+			Scopes:          scopes,
+		}, clientOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to impersonate service account %s: %w", cfg.impersonate, err)
+		}
+		clientOpts = []option.ClientOption{option.WithTokenSource(ts)}
+	}
+	if cfg.endpoint != "" {
+		clientOpts = append(clientOpts, option.WithEndpoint(cfg.endpoint))
+	}
+
+	//storage.NewClient honors STORAGE_EMULATOR_HOST itself, routing
+	//requests to the emulator and disabling authentication.
+	client, err := storage.NewClient(cfg.ctx, clientOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create GCS client: %w", err)
+	}
+	return client, clientOpts, nil
+}
+
+//storageBucket returns the handle of the named bucket, billing requests
+//to the user project if one is set.
+func (c *Client) storageBucket(name string) *storage.BucketHandle {
+	b := c.client.Bucket(name)
+	if c.userProject != "" {
+		b = b.UserProject(c.userProject)
 	}
+	return b
+}
+
+//ConnectProject initializes a Google Cloud Storage Client for projectID.
+//It is shorthand for New(WithProjectID(projectID)).
+func ConnectProject(projectID string) (*Client, error) {
+	return New(WithProjectID(projectID))
+}
+
+//ConnectWithClient is like Connect but adopts client, such as one
+//configured with a custom HTTP client or pointed at an emulator, instead
+//of creating one. It is shorthand for
+//Connect(WithStorageClient(client), WithProjectID(projectID)); projectID
+//may be empty as for New.
+func ConnectWithClient(client *storage.Client, projectID string) (*Client, error) {
+	return Connect(WithStorageClient(client), WithProjectID(projectID))
+}
 
-	gcs := createClient()
-	gcs.projectID = projectID
-	client, err := storage.NewClient(singleton.ctx)
+//MustConnect is like Connect but exits the program if the connection
+//cannot be established, preserving the original behavior of Connect.
+func MustConnect(opts ...Option) *Client {
+	gcs, err := Connect(opts...)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Unable to create GCS Client:", err)
+		fmt.Fprintln(os.Stderr, "GCS:", err)
 		os.Exit(1)
 	}
-	gcs.client = client
+	return gcs
+}
 
+//SetLogger routes the diagnostic output of c to logger.
+//By default a client logs nothing; a nil logger restores that.
+//SetLogger must not be called while c is in use by other goroutines.
+func (c *Client) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	c.log = logger
 }
 
-//createClient instantiates singleton Google Cloud Storage Client
-func createClient() *gcsClient {
-	once.Do(func() {
-		singleton = &gcsClient{
-			ctx: context.Background(),
-		}
-	})
-	return singleton
+//RawClient returns the underlying *storage.Client, as an escape hatch
+//for features this package does not offer, such as HMAC keys or bucket
+//notifications
+// - Calls through it bypass the conveniences of the package: there is no
+//compression, retry policy, integrity check or logging.
+// - Unless adopted with WithStorageClient, the client is owned by c: it
+//must not be closed, and it is closed by c.Close.
+func (c *Client) RawClient() *storage.Client {
+	return c.client
+}
+
+//Close releases the connections held by c. A client adopted with
+//WithStorageClient is left open for its owner to close.
+//Operations on c after Close return ErrClosed.
+func (c *Client) Close() error {
+	if c.closed.Swap(true) || !c.ownsClient {
+		return nil
+	}
+	return c.client.Close()
+}
+
+//withTimeout returns ctx bounded by the operation timeout of c, if any.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.opTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.opTimeout)
+}
+
+//wait blocks until the rate limit of c allows another operation, or ctx
+//is done.
+func (c *Client) wait(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.Wait(ctx)
+}
+
+//checkOpen returns ErrClosed if c has been closed.
+func (c *Client) checkOpen() error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	return nil
+}
+
+//Close closes the default client, so that a later Connect starts afresh.
+//Package-level functions return ErrNotConnected until then.
+func Close() error {
+	defaultMu.Lock()
+	c := defaultClient
+	defaultClient = nil
+	defaultMu.Unlock()
+
+	if c == nil {
+		return nil
+	}
+	return c.Close()
 }
 
-//setBucket sets bucket to pre-existing bucket or creates
-//new bucket.
-func setBucket(name string) error {
-	bucket := singleton.client.Bucket(name)
-	_, err := bucket.Attrs(singleton.ctx)
+//getDefault returns the default client or ErrNotConnected.
+func getDefault() (*Client, error) {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	if defaultClient == nil {
+		return nil, ErrNotConnected
+	}
+	return defaultClient, nil
+}
+
+//useBucket returns the handle of the pre-existing bucket name. If it
+//does not exist it is created with the attributes in opts.Bucket when
+//opts.Bucket.CreateIfMissing or WithBucketCreation is set; otherwise an
+//error wrapping ErrBucketNotFound is returned. Creation is idempotent,
+//so concurrent uploads to a new bucket do not fail when they race to
+//create it. Transient errors are retried according to opts.Retry.
+//The handle is local to the operation, so concurrent uploads to
+//different buckets do not share state.
+func (c *Client) useBucket(ctx context.Context, name string, opts UploadOpts) (Bucket, error) {
+	retry := opts.Retry
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	if err := validateBucketName(name); err != nil {
+		return nil, err
+	}
+
+	bucket := c.newBucket(name)
+	err := retry.do(ctx, c.log, func(ctx context.Context) error {
+		_, err := bucket.Attrs(ctx)
+		return err
+	})
 	if err != nil {
-		if err == storage.ErrBucketNotExist {
-			//Create Bucket
-			fmt.Printf("Creating bucket %s\n", name)
-			err := bucket.Create(singleton.ctx, singleton.projectID, nil)
-			if err != nil {
-				fmt.Println("Error creating bucket", err)
+		if err != storage.ErrBucketNotExist {
+			c.log.Error("GCS: Error reading bucket attributes", "bucket", name, "err", err)
+			return nil, gcsError(name, "", err)
+		}
+		if !opts.Bucket.CreateIfMissing && !c.createBuckets {
+			return nil, bucketNotFound(name, err)
+		}
+		if c.projectID == "" {
+			return nil, fmt.Errorf("unable to create bucket %s, GOOGLE_CLOUD_PROJECT not set and no WithProjectID: %w", name, ErrMissingEnv)
+		}
+
+		//Create Bucket
+		c.log.Info("GCS: Creating bucket", "bucket", name)
+		err := retry.do(ctx, c.log, func(ctx context.Context) error {
+			return bucket.Create(ctx, c.projectID, opts.Bucket.attrs())
+		})
+		if isConflict(err) {
+			//Another upload created the bucket since it was checked. Make
+			//sure it is ours to use rather than a name taken elsewhere.
+			err = retry.do(ctx, c.log, func(ctx context.Context) error {
+				_, err := bucket.Attrs(ctx)
 				return err
-			}
-		} else {
-			fmt.Println(err)
-			return err
+			})
+		}
+		if err != nil {
+			c.log.Error("GCS: Error creating bucket", "bucket", name, "err", err)
+			return nil, gcsError(name, "", err)
 		}
 	}
-	singleton.bucket = bucket
-	return nil
+	return bucket, nil
 }
 
-//Upload writes file to GCS bucket
-// - Gzip encodes / compresses the file before sending
-// - Sets GCS object property fields content-type and
-// content-encoding to 'text/plain' and 'gzip'.
+//Upload writes file to GCS bucket using the default client.
+//See Client.Upload.
 func Upload(bucket string, filename string) error {
-	err := setBucket(bucket)
+	c, err := getDefault()
 	if err != nil {
-		fmt.Println("GCS: Error setting bucket", err)
 		return err
 	}
+	return c.Upload(bucket, filename)
+}
 
-	data, err := ioutil.ReadFile(filename)
+//UploadReader streams r to GCS bucket using the default client.
+//See Client.UploadReader.
+func UploadReader(bucket string, objectName string, r io.Reader) error {
+	c, err := getDefault()
 	if err != nil {
-		fmt.Println("GCS: Error reading file for upload", err)
 		return err
 	}
+	return c.UploadReader(bucket, objectName, r)
+}
 
-	filename = path.Base(filename)
-	ext := path.Ext(filename)
-	objectName := filename[0:len(filename)-len(ext)] + ".gzip"
-	fmt.Printf("GCS: Uploading object %s\n", objectName)
+//UploadContext writes file to GCS bucket under ctx using the default client.
+//See Client.UploadContext.
+func UploadContext(ctx context.Context, bucket string, filename string) error {
+	c, err := getDefault()
+	if err != nil {
+		return err
+	}
+	return c.UploadContext(ctx, bucket, filename)
+}
 
-	wc := singleton.bucket.Object(objectName).NewWriter(singleton.ctx)
-	wc.ContentType = "text/plain"
-	wc.ContentEncoding = "gzip"
+//UploadWithOptions writes file to GCS bucket using the default client.
+//See Client.UploadWithOptions.
+func UploadWithOptions(ctx context.Context, bucket string, filename string, opts UploadOpts) (*UploadResult, error) {
+	c, err := getDefault()
+	if err != nil {
+		return nil, err
+	}
+	return c.UploadWithOptions(ctx, bucket, filename, opts)
+}
 
-	zWriter := gzip.NewWriter(wc)
-	nBytes, err := zWriter.Write(data)
+//UploadAs writes file to GCS bucket as objectName using the default client.
+//See Client.UploadAs.
+func UploadAs(bucket string, objectName string, filename string) error {
+	c, err := getDefault()
 	if err != nil {
-		fmt.Println("GCS: Error compressing file", err)
 		return err
 	}
-	zWriter.Close()
-	fmt.Printf("GCS: Wrote %d bytes\n", nBytes)
+	return c.UploadAs(bucket, objectName, filename)
+}
 
-	if err := wc.Close(); err != nil {
-		fmt.Println("GCS: Error on context writer close", err)
+//UploadOpts configures a single upload.
+type UploadOpts struct {
+	//Compress selects whether the data is compressed. Compressed data is
+	//encoded with Encoding and the object's content-encoding is set
+	//accordingly; derived object names keep the file name unless
+	//CompressedSuffix is set. Otherwise the data is stored as is, without
+	//content-encoding or suffix. The zero value, CompressNever, stores data
+	//uncompressed.
+	Compress CompressMode
+
+	//Encoding is the compression used when compressing. It defaults to
+	//EncodingGzip.
+	Encoding Encoding
+
+	//AlreadyCompressed uploads data that is already compressed with
+	//Encoding, such as a '.gz' file, as is: it is not compressed again but
+	//its content-encoding is set, so it is decompressed on download. The
+	//name of the file is kept, and Compress is ignored.
+	//When Encoding is not set it is taken from the extension: zstd for
+	//'.zst' and '.zstd', else gzip.
+	//ContentType should be the type of the decompressed data. When not set
+	//it is looked up from the extension before the compression extension,
+	//e.g. 'application/json' for 'data.json.gz', else
+	//'application/octet-stream' since compressed data cannot be sniffed.
+	AlreadyCompressed bool
+
+	//CompressionLevel is the level used when compressing. For gzip it
+	//ranges from gzip.HuffmanOnly to gzip.BestCompression; zstd maps it
+	//to the closest zstd encoder level. The zero value selects the client
+	//level set by WithCompressionLevel, gzip.DefaultCompression by default;
+	//to store data uncompressed set Compress to CompressNever.
+	CompressionLevel int
+
+	//CompressedSuffix is the suffix appended to derived names of compressed
+	//objects, an extension such as '.gz', which standard gzip tooling
+	//recognizes. The zero value selects the client suffix set by
+	//WithCompressedSuffix, else none: the compression is only signalled by
+	//the content-encoding.
+	CompressedSuffix string
+
+	//MinCompressSize stores data smaller than MinCompressSize bytes
+	//uncompressed, without content-encoding or suffix, since compressing
+	//tiny objects saves little and costs CPU on every read. Reader uploads
+	//buffer up to MinCompressSize bytes to decide. Zero compresses data of
+	//any size according to Compress.
+	MinCompressSize int64
+
+	//ContentType overrides the detected content type of the object.
+	ContentType string
+
+	//CacheControl is the Cache-Control header the object is served with,
+	//such as 'public, max-age=3600' for objects behind a CDN.
+	CacheControl string
+
+	//ContentDisposition is the Content-Disposition header the object is
+	//served with, such as 'attachment; filename="report.csv"' to set the
+	//name of downloaded files.
+	ContentDisposition string
+
+	//ContentLanguage is the Content-Language header the object is served
+	//with, a BCP 47 language tag such as 'en' or 'pt-BR', so that browsers
+	//and CDNs serve the right variant of localized documents.
+	ContentLanguage string
+
+	//ObjectName is the exact object key to write. When empty the name is
+	//computed by NameFunc, or else derived from the local filename.
+	ObjectName string
+
+	//NameFunc computes the object name of file uploads from the path of
+	//the local file when ObjectName is empty, e.g. to bucket objects by
+	//upload date:
+	//
+	//	NameFunc: func(localPath string) string {
+	//		return time.Now().UTC().Format("2006/01/02/") + filepath.Base(localPath)
+	//	}
+	//
+	//The name is used as is, without compression suffix, and Prefix is
+	//still prepended. When nil the name is derived from the file name.
+	NameFunc func(localPath string) string
+
+	//Prefix is prepended to the object name as a virtual folder, joined
+	//with a single '/', so 'logs/2024/' and 'bar.log' give 'logs/2024/bar.log'.
+	Prefix string
+
+	//Retry is the policy for transient errors. File uploads are retried
+	//from the start of the file; reader uploads only retry the bucket
+	//checks since a consumed reader cannot be replayed.
+	Retry RetryPolicy
+
+	//SendCRC32C has file uploads checksum the file in a first pass and send
+	//the CRC32C with the data, so GCS rejects a corrupted write instead of
+	//committing it, even for single-request uploads where the checksum the
+	//storage writer computes while streaming may be validated too late. It
+	//costs an extra read and compression of the file. Every upload is
+	//verified against the stored CRC32C regardless.
+	SendCRC32C bool
+
+	//SkipIfUnchanged has file uploads compare the CRC32C of the data as it
+	//would be stored with that of the existing object, and skip the upload
+	//if they match and the object already has the content type, headers
+	//and Metadata of the upload. The returned UploadResult then describes
+	//the existing object and has Skipped set. Like SendCRC32C it costs an
+	//extra pass.
+	SkipIfUnchanged bool
+
+	//DoesNotExist makes the upload fail with ErrPreconditionFailed if the
+	//object already exists, giving write-once semantics.
+	DoesNotExist bool
+
+	//GenerationMatch makes the upload fail with ErrPreconditionFailed
+	//unless the live generation of the object is GenerationMatch, for
+	//optimistic concurrency. It cannot be combined with DoesNotExist.
+	GenerationMatch int64
+
+	//StorageClass is the storage class of the object, one of "STANDARD",
+	//"NEARLINE", "COLDLINE" or "ARCHIVE". It defaults to the bucket's.
+	StorageClass string
+
+	//PublicRead makes the object readable by anyone, through the URL of
+	//the UploadResult, e.g. for static assets behind a CDN. It fails on
+	//buckets with uniform bucket-level access, where object ACLs are
+	//disabled and public access must be granted on the bucket instead, and
+	//is ineffective with public access prevention; buckets created by the
+	//upload need both relaxed in Bucket.BucketSecurity.
+	PublicRead bool
+
+	//TemporaryHold places a temporary hold on the object, which prevents
+	//deleting or replacing it until the hold is released.
+	TemporaryHold bool
+
+	//EventBasedHold places an event-based hold on the object: it cannot be
+	//deleted or replaced until the hold is released, and the retention
+	//period of the bucket only starts counting from then.
+	EventBasedHold bool
+
+	//KMSKeyName is the Cloud KMS key the object is encrypted with, in the
+	//form 'projects/P/locations/L/keyRings/R/cryptoKeys/K'. It defaults to
+	//the bucket's default key, if any, else to a Google-managed key.
+	KMSKeyName string
+
+	//EncryptionKey is a customer-supplied AES-256 key the object is
+	//encrypted with. GCS does not store the key: the same key must be
+	//given in DownloadOpts to read the object back. It cannot be combined
+	//with KMSKeyName.
+	EncryptionKey []byte
+
+	//CustomTime is a business-meaningful time of the object, such as the
+	//date of a backfilled document, that lifecycle rules can key off
+	//instead of the upload time, e.g. to delete objects N days after it.
+	//The zero value leaves it unset. GCS only allows it to move forward
+	//when the object is replaced or updated.
+	CustomTime time.Time
+
+	//Metadata is custom metadata set on the object, such as a source host
+	//or schema version. Keys and values must not be empty. File uploads
+	//also record the base name of the file under OriginalFilenameKey,
+	//unless Metadata sets it.
+	Metadata map[string]string
+
+	//Bucket controls whether a missing bucket is created, and with which
+	//attributes. By default uploads to a missing bucket fail.
+	Bucket BucketOpts
+
+	//ChunkSize is the size of the chunks a resumable upload sends, which
+	//the writer buffers in memory. It is rounded up to a multiple of
+	//256KiB. Larger chunks suit big files on fast links; a negative value
+	//disables buffering and sends the object in a single request, which
+	//suits small files but cannot be resumed. Zero uses the 16MiB default.
+	ChunkSize int
+
+	//Resume, if not nil, uploads the file through a resumable upload
+	//session recorded in *Resume, so that an interrupted upload continues
+	//from the last chunk GCS persisted rather than from the start, whether
+	//retried by Retry or by a later call passing the same state, e.g. after
+	//a restart. The zero ResumeState starts a new session. The file must
+	//not change in between, nor the options of a compressed upload, since
+	//the stream is encoded again and the bytes already persisted skipped.
+	//Once the upload completes *Resume is reset to the zero ResumeState, so
+	//that it can be passed to the next upload.
+	//Not supported by reader, batch and directory uploads, clients adopted
+	//with WithStorageClient or a negative ChunkSize. When nil, the default,
+	//uploads go through the storage writer and restart on retry.
+	Resume *ResumeState
+
+	//Checkpoint, if set, is called with the state of the session of Resume
+	//when it starts, after every chunk persisted and, with the zero state,
+	//once the upload completes, e.g. to save it to a file. An error aborts
+	//the upload, keeping the session.
+	Checkpoint func(ResumeState) error
+
+	//Progress, if set, is called with the number of bytes of the source
+	//streamed so far, once per chunk of up to 32KB, e.g. to render a
+	//progress bar against the file size. It runs on the uploading
+	//goroutine, so it should return quickly. A retried upload reports
+	//from zero again.
+	Progress func(bytesWritten int64)
+}
+
+//validateCompressedSuffix returns an error if suffix is neither empty nor
+//an extension such as '.gz': a suffix without a leading dot would run
+//into the name, e.g. 'data.jsongz'.
+func validateCompressedSuffix(suffix string) error {
+	switch {
+	case suffix == "":
+		return nil
+	case len(suffix) < 2 || suffix[0] != '.':
+		return fmt.Errorf("invalid compressed suffix %q, want an extension such as '.gz'", suffix)
+	case strings.Contains(suffix, "/"):
+		return fmt.Errorf("invalid compressed suffix %q, must not contain '/'", suffix)
+	}
+	return nil
+}
+
+//validate reports an error for option values that are out of range.
+func (o UploadOpts) validate() error {
+	if o.CompressionLevel < gzip.HuffmanOnly || o.CompressionLevel > gzip.BestCompression {
+		return fmt.Errorf("invalid gzip compression level %d", o.CompressionLevel)
+	}
+	if err := validateCompressedSuffix(o.CompressedSuffix); err != nil {
+		return err
+	}
+	if o.MinCompressSize < 0 {
+		return fmt.Errorf("invalid minimum compression size %d", o.MinCompressSize)
+	}
+	if o.Resume != nil && o.ChunkSize < 0 {
+		return errors.New("Resume requires chunked uploads, not a negative ChunkSize")
+	}
+	if o.Resume == nil && o.Checkpoint != nil {
+		return errors.New("Checkpoint requires Resume")
+	}
+	if err := o.Compress.validate(); err != nil {
+		return err
+	}
+	if err := o.Encoding.validate(); err != nil {
+		return err
+	}
+	if o.DoesNotExist && o.GenerationMatch != 0 {
+		return errors.New("DoesNotExist and GenerationMatch are mutually exclusive")
+	}
+	if err := validateStorageClass(o.StorageClass); err != nil {
+		return err
+	}
+	if err := validateHeader("Cache-Control", o.CacheControl); err != nil {
+		return err
+	}
+	if err := validateHeader("Content-Disposition", o.ContentDisposition); err != nil {
+		return err
+	}
+	if o.ContentDisposition != "" {
+		if _, _, err := mime.ParseMediaType(o.ContentDisposition); err != nil {
+			return fmt.Errorf("invalid Content-Disposition %q: %w", o.ContentDisposition, err)
+		}
+	}
+	if o.ContentLanguage != "" && !languageTag.MatchString(o.ContentLanguage) {
+		return fmt.Errorf("invalid Content-Language %q, want a language tag such as 'en' or 'pt-BR'", o.ContentLanguage)
+	}
+	if err := validateKMSKeyName(o.KMSKeyName); err != nil {
+		return err
+	}
+	if err := validateEncryptionKey(o.EncryptionKey); err != nil {
 		return err
 	}
+	if o.KMSKeyName != "" && len(o.EncryptionKey) != 0 {
+		return errors.New("KMSKeyName and EncryptionKey are mutually exclusive")
+	}
+	if !o.CustomTime.IsZero() && (o.CustomTime.Year() < 1 || o.CustomTime.Year() > 9999) {
+		return fmt.Errorf("invalid custom time %s, must be between years 1 and 9999", o.CustomTime)
+	}
+	for k, v := range o.Metadata {
+		if k == "" || v == "" {
+			return fmt.Errorf("invalid metadata %q: %q, keys and values must not be empty", k, v)
+		}
+	}
+	return o.Bucket.validate()
+}
 
+//chunkSize returns the writer chunk size for o.ChunkSize: -1 for the
+//default, 0 to disable buffering, otherwise a multiple of 256KiB.
+func (o UploadOpts) chunkSize() int {
+	switch {
+	case o.ChunkSize == 0:
+		return -1
+	case o.ChunkSize < 0:
+		return 0
+	}
+	return (o.ChunkSize + chunkSizeMultiple - 1) / chunkSizeMultiple * chunkSizeMultiple
+}
+
+//validateHeader returns an error if value, the value of the header
+//name, contains characters not allowed in HTTP header values.
+func validateHeader(name string, value string) error {
+	for _, r := range value {
+		if r < ' ' && r != '\t' || r == 0x7f {
+			return fmt.Errorf("invalid %s %q, must not contain control characters", name, value)
+		}
+	}
 	return nil
 }
+
+//languageTag loosely matches a BCP 47 language tag: subtags of up to 8
+//letters or digits separated by '-', the first made of letters.
+var languageTag = regexp.MustCompile(`^[A-Za-z]{1,8}(-[A-Za-z0-9]{1,8})*$`)
+
+//conditions returns the preconditions of the write, or nil if there are
+//none.
+func (o UploadOpts) conditions() *storage.Conditions {
+	if !o.DoesNotExist && o.GenerationMatch == 0 {
+		return nil
+	}
+	return &storage.Conditions{
+		DoesNotExist:    o.DoesNotExist,
+		GenerationMatch: o.GenerationMatch,
+	}
+}
+
+//withDefaults fills the fields of opts left at their zero value with the
+//defaults configured on c.
+func (c *Client) withDefaults(opts UploadOpts) UploadOpts {
+	if opts.CompressionLevel == 0 {
+		opts.CompressionLevel = c.compressionLevel
+	}
+	if opts.AlreadyCompressed {
+		opts.Compress = CompressNever
+	}
+	if opts.Retry.Deadline == 0 {
+		opts.Retry.Deadline = c.retryDeadline
+	}
+	if opts.CompressedSuffix == "" {
+		opts.CompressedSuffix = c.compressedSuffix
+	}
+	return opts
+}
+
+//defaultUploadOpts are the options used by Upload and UploadReader.
+var defaultUploadOpts = UploadOpts{Compress: CompressAlways}
+
+//Upload writes file to GCS bucket
+// - Gzip encodes / compresses the file while streaming it, so memory use
+//does not grow with the file size.
+// - Sets GCS object property content-encoding to 'gzip' and content-type
+//to the type detected from the file extension or, failing that, the data.
+// - Fails with an error wrapping ErrBucketNotFound if bucket does not
+//exist; see BucketOpts.CreateIfMissing and WithBucketCreation.
+func (c *Client) Upload(bucket string, filename string) error {
+	return c.UploadContext(c.ctx, bucket, filename)
+}
+
+//UploadContext is like Upload but runs under ctx.
+//If ctx is cancelled or its deadline passes, the upload is aborted and no
+//partial object is committed.
+func (c *Client) UploadContext(ctx context.Context, bucket string, filename string) error {
+	_, err := c.UploadWithOptions(ctx, bucket, filename, defaultUploadOpts)
+	return err
+}
+
+//UploadAs is like Upload but writes the file to the exact object key
+//objectName, such as 'year/month/day/host.log', instead of deriving it
+//from the filename.
+func (c *Client) UploadAs(bucket string, objectName string, filename string) error {
+	opts := defaultUploadOpts
+	opts.ObjectName = objectName
+	_, err := c.UploadWithOptions(c.ctx, bucket, filename, opts)
+	return err
+}
+
+//UploadWithOptions is like UploadContext but configured by opts, and
+//returns a description of the object written.
+func (c *Client) UploadWithOptions(ctx context.Context, bucket string, filename string, opts UploadOpts) (*UploadResult, error) {
+	start := time.Now()
+	objectName, result, err := c.uploadFile(ctx, bucket, filename, opts)
+	c.hooks.done(objectName, result, err, start)
+	return result, err
+}
+
+//openLocalFile opens filename for upload. Errors wrap ErrLocalFile and
+//their cause, such as fs.ErrNotExist or fs.ErrPermission, and a
+//directory is rejected with an error wrapping syscall.EISDIR.
+func (c *Client) openLocalFile(filename string) (*os.File, fs.FileInfo, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		c.log.Error("GCS: Error reading file for upload", "file", filename, "err", err)
+		return nil, nil, fmt.Errorf("%w: %w", ErrLocalFile, err)
+	}
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = &fs.PathError{Op: "upload", Path: filename, Err: syscall.EISDIR}
+		c.log.Error("GCS: Error reading file for upload", "file", filename, "err", err)
+		file.Close()
+		return nil, nil, fmt.Errorf("%w: %w, see UploadDir", ErrLocalFile, err)
+	}
+	if err != nil {
+		c.log.Error("GCS: Error reading file for upload", "file", filename, "err", err)
+		file.Close()
+		return nil, nil, fmt.Errorf("%w: %w", ErrLocalFile, err)
+	}
+	return file, info, nil
+}
+
+//uploadFile implements UploadWithOptions. It also returns the name of the
+//object, as far as it is known when failing early.
+func (c *Client) uploadFile(ctx context.Context, bucket string, filename string, opts UploadOpts) (string, *UploadResult, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	opts = c.withDefaults(opts)
+	opts.Metadata = withOriginalFilename(opts.Metadata, filename)
+	if opts.ObjectName == "" && opts.NameFunc != nil {
+		opts.ObjectName = opts.NameFunc(filename)
+	}
+	objectName := withPrefix(opts.Prefix, objectNameFor(filename, opts))
+	if err := opts.validate(); err != nil {
+		return objectName, nil, err
+	}
+
+	handle, err := c.useBucket(ctx, bucket, opts)
+	if err != nil {
+		c.log.Error("GCS: Error setting bucket", "bucket", bucket, "err", err)
+		return objectName, nil, err
+	}
+
+	file, info, err := c.openLocalFile(filename)
+	if err != nil {
+		return objectName, nil, err
+	}
+	defer file.Close()
+	f := localFile{file}
+
+	if opts.Encoding == "" && opts.AlreadyCompressed {
+		opts.Encoding = precompressedEncoding(filename)
+	}
+	if opts.ContentType == "" && opts.AlreadyCompressed {
+		opts.ContentType = precompressedContentType(filename)
+	}
+	if opts.ContentType == "" {
+		opts.ContentType, _ = detectContentType(filename, f)
+	}
+	if opts.MinCompressSize > 0 && info.Size() < opts.MinCompressSize {
+		opts.Compress = CompressNever
+	}
+	if opts.Compress == CompressAuto {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return objectName, nil, err
+		}
+		opts.Compress, _ = autoCompress(opts.ContentType, f)
+	}
+
+	objectName = withPrefix(opts.Prefix, objectNameFor(filename, opts))
+	c.hooks.start(objectName)
+	c.log.Info("GCS: Uploading object", "object", objectName)
+
+	var sendCRC *uint32
+	if opts.SendCRC32C || opts.SkipIfUnchanged {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return objectName, nil, err
+		}
+		crc, err := encodedCRC32C(f, opts)
+		if err != nil {
+			c.log.Error("GCS: Error reading file for upload", "file", filename, "err", err)
+			return objectName, nil, err
+		}
+		sendCRC = &crc
+	}
+
+	if opts.SkipIfUnchanged {
+		existing, err := c.unchangedObject(ctx, handle, objectName, *sendCRC, opts)
+		if err != nil {
+			return objectName, nil, err
+		}
+		if existing != nil {
+			c.log.Info("GCS: Skipping unchanged object", "object", objectName)
+			result := newUploadResult(existing)
+			result.SourceSize = info.Size()
+			result.Skipped = true
+			return objectName, result, nil
+		}
+	}
+
+	//Every attempt rewinds the file and uploads it from the start, or
+	//with opts.Resume skips the bytes its session already persisted.
+	var nBytes int64
+	var attrs *storage.ObjectAttrs
+	err = opts.Retry.do(ctx, c.log, func(ctx context.Context) error {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		nBytes, attrs, err = c.writeObject(ctx, handle, objectName, f, opts, sendCRC)
+		return err
+	})
+	if err != nil {
+		return objectName, nil, err
+	}
+	result := newUploadResult(attrs)
+	result.SourceSize = nBytes
+	c.log.Info("GCS: Wrote bytes", "object", objectName, "bytes", nBytes, "stored", result.Size)
+	return objectName, result, nil
+}
+
+//UploadReader streams r into the named object of a GCS bucket
+// - Gzip encodes / compresses the stream as it is written, so the payload
+//is never buffered in memory.
+// - objectName is used as is; no name is derived since there is no file.
+// - If r fails midway the upload is aborted and no object is committed.
+func (c *Client) UploadReader(bucket string, objectName string, r io.Reader) error {
+	return c.UploadReaderContext(c.ctx, bucket, objectName, r)
+}
+
+//UploadReaderContext is like UploadReader but runs under ctx.
+//If ctx is cancelled or its deadline passes, the upload is aborted and no
+//partial object is committed.
+func (c *Client) UploadReaderContext(ctx context.Context, bucket string, objectName string, r io.Reader) error {
+	_, err := c.UploadReaderWithOptions(ctx, bucket, objectName, r, defaultUploadOpts)
+	return err
+}
+
+//UploadReaderWithOptions is like UploadReaderContext but configured by
+//opts, and returns a description of the object written.
+func (c *Client) UploadReaderWithOptions(ctx context.Context, bucket string, objectName string, r io.Reader, opts UploadOpts) (*UploadResult, error) {
+	start := time.Now()
+	name := withPrefix(opts.Prefix, objectName)
+	result, err := c.uploadReader(ctx, bucket, objectName, r, opts)
+	c.hooks.done(name, result, err, start)
+	return result, err
+}
+
+//uploadReader implements UploadReaderWithOptions.
+func (c *Client) uploadReader(ctx context.Context, bucket string, objectName string, r io.Reader, opts UploadOpts) (*UploadResult, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	opts = c.withDefaults(opts)
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Resume != nil {
+		//A reader cannot be read again to continue a session.
+		return nil, errors.New("Resume is not supported by reader uploads")
+	}
+
+	handle, err := c.useBucket(ctx, bucket, opts)
+	if err != nil {
+		c.log.Error("GCS: Error setting bucket", "bucket", bucket, "err", err)
+		return nil, err
+	}
+
+	if opts.Encoding == "" && opts.AlreadyCompressed {
+		opts.Encoding = precompressedEncoding(objectName)
+	}
+	if opts.ContentType == "" && opts.AlreadyCompressed {
+		opts.ContentType = precompressedContentType(objectName)
+	}
+	if opts.ContentType == "" {
+		opts.ContentType, r = detectContentType(objectName, r)
+	}
+	if opts.Compress != CompressNever && opts.MinCompressSize > 0 {
+		var small bool
+		small, r, err = shorterThan(r, opts.MinCompressSize)
+		if err != nil {
+			c.log.Error("GCS: Error reading data for upload", "object", objectName, "err", err)
+			return nil, err
+		}
+		if small {
+			opts.Compress = CompressNever
+		}
+	}
+	if opts.Compress == CompressAuto {
+		opts.Compress, r = autoCompress(opts.ContentType, r)
+	}
+	objectName = withPrefix(opts.Prefix, objectName)
+
+	c.hooks.start(objectName)
+	c.log.Info("GCS: Uploading object", "object", objectName)
+	nBytes, attrs, err := c.writeObject(ctx, handle, objectName, r, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := newUploadResult(attrs)
+	result.SourceSize = nBytes
+	c.log.Info("GCS: Wrote bytes", "object", objectName, "bytes", nBytes, "stored", result.Size)
+	return result, nil
+}
+
+//UploadStdin streams standard input into the named object of a GCS
+//bucket as UploadReader does, for use in shell pipelines such as
+//'cat dump.sql | tool upload'
+// - The length of the input need not be known; it is read until EOF.
+// - The content type is detected from the extension of objectName or,
+//failing that, by sniffing the first bytes of the input.
+func (c *Client) UploadStdin(bucket string, objectName string) error {
+	return c.UploadReader(bucket, objectName, os.Stdin)
+}
+
+//UploadBytes writes data into the named object of a GCS bucket as
+//UploadReader does, for data generated in memory such as reports
+// - objectName is used as is.
+// - The content type is detected from the extension of objectName or,
+//failing that, from data; use UploadBytesWithOptions to set it.
+func (c *Client) UploadBytes(bucket string, objectName string, data []byte) error {
+	_, err := c.UploadBytesWithOptions(c.ctx, bucket, objectName, data, defaultUploadOpts)
+	return err
+}
+
+//UploadBytesWithOptions is like UploadBytes but runs under ctx and is
+//configured by opts, and returns a description of the object written.
+func (c *Client) UploadBytesWithOptions(ctx context.Context, bucket string, objectName string, data []byte, opts UploadOpts) (*UploadResult, error) {
+	return c.UploadReaderWithOptions(ctx, bucket, objectName, bytes.NewReader(data), opts)
+}
+
+//objectNameFor returns the object name for the local file filename: an
+//explicit opts.ObjectName unchanged, otherwise derivedObjectName.
+func objectNameFor(filename string, opts UploadOpts) string {
+	if opts.ObjectName != "" {
+		return opts.ObjectName
+	}
+	return derivedObjectName(filename, opts)
+}
+
+//derivedObjectName derives the object name for the local file filename.
+//The base name is kept whole, including every extension, so 'data.json',
+//'archive.tar.gz' and a file without extension such as 'README' keep
+//their names, compressed or not; the compression is signalled by the
+//content-encoding only. If opts.CompressedSuffix is set it is appended to
+//the names of compressed objects, e.g. 'data.json.gz' for '.gz'.
+func derivedObjectName(filename string, opts UploadOpts) string {
+	objectName := filepath.Base(filename)
+	if opts.compressed() {
+		objectName += opts.CompressedSuffix
+	}
+	return objectName
+}
+
+//unchangedObject returns the attributes of objectName in bucket if it
+//exists and its CRC32C is crc, or nil if it must be uploaded.
+func (c *Client) unchangedObject(ctx context.Context, bucket Bucket, objectName string, crc uint32, opts UploadOpts) (*storage.ObjectAttrs, error) {
+	var attrs *storage.ObjectAttrs
+	err := opts.Retry.do(ctx, c.log, func(ctx context.Context) error {
+		var err error
+		attrs, err = bucket.ObjectAttrs(ctx, objectName, opts.EncryptionKey)
+		return err
+	})
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		c.log.Error("GCS: Error reading object attributes", "object", objectName, "err", err)
+		return nil, gcsError(bucket.BucketName(), objectName, err)
+	}
+	if attrs.CRC32C != crc || !sameAttrs(attrs, opts) {
+		return nil, nil
+	}
+	return attrs, nil
+}
+
+//sameAttrs reports whether the existing object attrs already has the
+//headers and custom metadata an upload with opts would set, so that an
+//object differing only in metadata is not skipped as unchanged.
+func sameAttrs(attrs *storage.ObjectAttrs, opts UploadOpts) bool {
+	return attrs.ContentType == opts.ContentType &&
+		attrs.CacheControl == opts.CacheControl &&
+		attrs.ContentDisposition == opts.ContentDisposition &&
+		attrs.ContentLanguage == opts.ContentLanguage &&
+		maps.Equal(attrs.Metadata, opts.Metadata)
+}
+
+//isUniformAccessRejection reports whether err is GCS rejecting an object
+//ACL because the bucket has uniform bucket-level access enabled.
+func isUniformAccessRejection(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusBadRequest {
+		return false
+	}
+	return strings.Contains(strings.ToLower(gerr.Message), "uniform bucket-level access")
+}
+
+//isConflict reports whether err is GCS rejecting a request because the
+//resource it creates already exists (HTTP 409).
+func isConflict(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusConflict
+}
+
+//isPreconditionFailure reports whether err is GCS rejecting a request
+//because its preconditions did not hold.
+func isPreconditionFailure(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
+
+//withPrefix joins prefix and objectName with a single '/'.
+//Repeated slashes in prefix and slashes at the joint are collapsed, and a
+//leading slash is dropped since object names should not start with one.
+func withPrefix(prefix string, objectName string) string {
+	if prefix == "" {
+		return objectName
+	}
+	for strings.Contains(prefix, "//") {
+		prefix = strings.ReplaceAll(prefix, "//", "/")
+	}
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return strings.TrimLeft(objectName, "/")
+	}
+	return prefix + "/" + strings.TrimLeft(objectName, "/")
+}
+
+//detectContentType returns the content type for data named name and read
+//from r, along with a reader that yields the full data.
+//The type is looked up from the extension of name; when that is unknown
+//the first 512 bytes of r are sniffed with http.DetectContentType.
+func detectContentType(name string, r io.Reader) (string, io.Reader) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType, r
+	}
+
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	return http.DetectContentType(head), br
+}
+
+//writeObject writes r into objectName in bucket, compressing
+//it if opts.Compress is CompressAlways, and returns the number of
+//uncompressed bytes read from r and the attributes of the new object.
+//The bytes sent are checksummed as they stream, with the hardware
+//accelerated Castagnoli table, and compared with the CRC32C GCS stored;
+//on mismatch the object is deleted and an ErrIntegrity error returned.
+//The storage writer also checksums the stream and sends the CRC32C as it
+//finalizes the upload, so GCS validates it server-side without the data
+//being buffered. If sendCRC is not nil it is sent instead, computed ahead
+//of the write.
+//The compressor is always closed before the GCS writer; on error the
+//GCS write is cancelled so a partial object is never committed.
+func (c *Client) writeObject(ctx context.Context, bucket Bucket, objectName string, r io.Reader, opts UploadOpts, sendCRC *uint32) (int64, *storage.ObjectAttrs, error) {
+	if err := c.wait(ctx); err != nil {
+		return 0, nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req := &WriteRequest{
+		Attrs: storage.ObjectAttrs{
+			Name:               objectName,
+			ContentType:        opts.ContentType,
+			CacheControl:       opts.CacheControl,
+			ContentDisposition: opts.ContentDisposition,
+			ContentLanguage:    opts.ContentLanguage,
+			StorageClass:       opts.StorageClass,
+			KMSKeyName:         opts.KMSKeyName,
+			TemporaryHold:      opts.TemporaryHold,
+			EventBasedHold:     opts.EventBasedHold,
+			CustomTime:         opts.CustomTime,
+			Metadata:           opts.Metadata,
+		},
+	}
+	if opts.compressed() || opts.AlreadyCompressed {
+		req.Attrs.ContentEncoding = opts.Encoding.contentEncoding()
+	}
+	if opts.PublicRead {
+		req.Attrs.PredefinedACL = "publicRead"
+	}
+	if sendCRC != nil {
+		req.Attrs.CRC32C = *sendCRC
+		req.SendCRC32C = true
+	}
+	req.Conditions = opts.conditions()
+	req.ChunkSize = opts.chunkSize()
+	req.EncryptionKey = opts.EncryptionKey
+	req.Resume = opts.Resume
+	req.Checkpoint = opts.Checkpoint
+	wc := bucket.NewWriter(ctx, req)
+
+	if opts.Progress != nil {
+		r = &progressReader{r: r, fn: opts.Progress}
+	}
+	var dst io.Writer = wc
+	if c.bandwidth != nil {
+		dst = throttledWriter{ctx, wc, c.bandwidth}
+	}
+	h := crc32.New(crc32cTable)
+	nBytes, err := encode(io.MultiWriter(dst, h), r, opts)
+	if err != nil {
+		c.log.Error("GCS: Error writing stream", "object", objectName, "err", err)
+		cancel()
+		wc.Close()
+		return nBytes, nil, err
+	}
+
+	if err := wc.Close(); err != nil {
+		c.log.Error("GCS: Error on context writer close", "object", objectName, "err", err)
+		if isChecksumRejection(err) {
+			return nBytes, nil, fmt.Errorf("%w: %s rejected by GCS: %v", ErrIntegrity, objectName, err)
+		}
+		if isPreconditionFailure(err) {
+			return nBytes, nil, fmt.Errorf("%w: %s: %w", ErrPreconditionFailed, objectName, err)
+		}
+		if opts.PublicRead && isUniformAccessRejection(err) {
+			return nBytes, nil, fmt.Errorf("unable to make %s public: the bucket has uniform bucket-level access, "+
+				"so object ACLs are disabled; grant allUsers read access on the bucket instead: %w", objectName, err)
+		}
+		return nBytes, nil, gcsError(bucket.BucketName(), objectName, err)
+	}
+
+	attrs := wc.Attrs()
+	if attrs != nil && attrs.CRC32C != h.Sum32() {
+		c.log.Warn("GCS: Deleting corrupted object", "object", objectName)
+		bucket.DeleteGeneration(ctx, objectName, attrs.Generation)
+		return nBytes, nil, fmt.Errorf("%w: %s sent crc32c %08x, stored %08x", ErrIntegrity, objectName, h.Sum32(), attrs.CRC32C)
+	}
+	return nBytes, attrs, nil
+}
@@ -0,0 +1,313 @@
+//Package gcsfake provides in-memory GCS buckets, so that code uploading
+//with a gcs.Client can be tested without a live bucket or an emulator:
+//
+//	server := gcsfake.New()
+//	server.CreateBucket("my-bucket")
+//	client, err := gcs.New(gcs.WithStorageClient(sc), gcs.WithBucketFactory(server.Bucket))
+//
+//The upload path of the client goes through the fake by
+//gcs.WithBucketFactory; listing, deleting and downloading objects go
+//through the storage client, which can be pointed at Server.Handler.
+//Writes behave as on GCS where uploads depend on
+//it: objects are committed on Close only, unless the write is cancelled,
+//preconditions fail with HTTP 412, a CRC32C sent with the data that does
+//not match it fails with HTTP 400, and creating an existing bucket fails
+//with HTTP 409.
+package gcsfake
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/jeromeku/go-gcs/gcs"
+	"google.golang.org/api/googleapi"
+)
+
+//crc32cTable is the Castagnoli table GCS checksums objects with.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+//Object is an object stored by a Server.
+type Object struct {
+	//Attrs are the attributes of the object, as GCS would return them.
+	Attrs storage.ObjectAttrs
+	//Data is the stored data, compressed if the upload compressed it.
+	Data []byte
+}
+
+//Server holds in-memory buckets. It is safe for concurrent use.
+type Server struct {
+	//OnCreate, if set, is called before a bucket is created, e.g. to
+	//synchronize concurrent uploads racing to create it. It must be set
+	//before the Server is used.
+	OnCreate func(bucket string)
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	//generation is the generation of the last object written.
+	generation int64
+	//writeErrs are the errors the next writes fail with, in order.
+	writeErrs []error
+}
+
+//bucket is a bucket of a Server.
+type bucket struct {
+	attrs   storage.BucketAttrs
+	objects map[string]*Object
+}
+
+//New returns a Server without buckets.
+func New() *Server {
+	return &Server{buckets: map[string]*bucket{}}
+}
+
+//CreateBucket creates the bucket name, if it does not exist.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.createLocked(name, &storage.BucketAttrs{})
+}
+
+//createLocked creates the bucket name with attrs and reports whether it
+//did not exist.
+func (s *Server) createLocked(name string, attrs *storage.BucketAttrs) bool {
+	if _, ok := s.buckets[name]; ok {
+		return false
+	}
+	b := &bucket{attrs: *attrs, objects: map[string]*Object{}}
+	b.attrs.Name = name
+	b.attrs.Created = time.Now()
+	s.buckets[name] = b
+	return true
+}
+
+//HasBucket reports whether the bucket name exists.
+func (s *Server) HasBucket(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.buckets[name]
+	return ok
+}
+
+//Object returns a copy of the object name in bucket, if it exists.
+func (s *Server) Object(bucket string, name string) (*Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket]
+	if !ok {
+		return nil, false
+	}
+	obj, ok := b.objects[name]
+	if !ok {
+		return nil, false
+	}
+	return &Object{Attrs: obj.Attrs, Data: bytes.Clone(obj.Data)}, true
+}
+
+//ObjectNames returns the names of the objects in bucket, sorted.
+func (s *Server) ObjectNames(bucket string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	if b, ok := s.buckets[bucket]; ok {
+		for name := range b.objects {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+//FailWrites makes the next len(errs) writes fail with errs, in order,
+//when closed, e.g. with a *googleapi.Error of code 503 to test retries.
+func (s *Server) FailWrites(errs ...error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeErrs = append(s.writeErrs, errs...)
+}
+
+//Bucket returns the bucket name of s. It can be passed to
+//gcs.WithBucketFactory.
+func (s *Server) Bucket(name string) gcs.Bucket {
+	return &handle{s: s, name: name}
+}
+
+//handle implements gcs.Bucket for a bucket of a Server.
+type handle struct {
+	s    *Server
+	name string
+}
+
+//BucketName returns the name of the bucket.
+func (h *handle) BucketName() string {
+	return h.name
+}
+
+//Attrs returns the attributes of the bucket, or storage.ErrBucketNotExist.
+func (h *handle) Attrs(ctx context.Context) (*storage.BucketAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	b, ok := h.s.buckets[h.name]
+	if !ok {
+		return nil, storage.ErrBucketNotExist
+	}
+	attrs := b.attrs
+	return &attrs, nil
+}
+
+//Create creates the bucket, failing with HTTP 409 if it exists.
+func (h *handle) Create(ctx context.Context, projectID string, attrs *storage.BucketAttrs) error {
+	if h.s.OnCreate != nil {
+		h.s.OnCreate(h.name)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if attrs == nil {
+		attrs = &storage.BucketAttrs{}
+	}
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	if !h.s.createLocked(h.name, attrs) {
+		return &googleapi.Error{Code: http.StatusConflict, Message: "Your previous request to create the named bucket succeeded and you already own it."}
+	}
+	return nil
+}
+
+//NewWriter returns a writer committing the object of req on Close.
+func (h *handle) NewWriter(ctx context.Context, req *gcs.WriteRequest) gcs.ObjectWriter {
+	return &writer{ctx: ctx, h: h, req: req}
+}
+
+//ObjectAttrs returns the attributes of objectName, or
+//storage.ErrObjectNotExist. Encryption keys are not checked.
+func (h *handle) ObjectAttrs(ctx context.Context, objectName string, key []byte) (*storage.ObjectAttrs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	b, ok := h.s.buckets[h.name]
+	if !ok {
+		return nil, storage.ErrBucketNotExist
+	}
+	obj, ok := b.objects[objectName]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	attrs := obj.Attrs
+	return &attrs, nil
+}
+
+//DeleteGeneration deletes objectName if its live generation is
+//generation, failing with HTTP 412 otherwise.
+func (h *handle) DeleteGeneration(ctx context.Context, objectName string, generation int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	b, ok := h.s.buckets[h.name]
+	if !ok {
+		return storage.ErrBucketNotExist
+	}
+	obj, ok := b.objects[objectName]
+	if !ok {
+		return storage.ErrObjectNotExist
+	}
+	if obj.Attrs.Generation != generation {
+		return preconditionFailed()
+	}
+	delete(b.objects, objectName)
+	return nil
+}
+
+//writer buffers the data of an object until Close.
+type writer struct {
+	ctx   context.Context
+	h     *handle
+	req   *gcs.WriteRequest
+	buf   bytes.Buffer
+	attrs *storage.ObjectAttrs
+}
+
+//Write buffers b, failing once the context of the writer is done.
+func (w *writer) Write(b []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.buf.Write(b)
+}
+
+//Close commits the object, unless the write was cancelled or fails.
+func (w *writer) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	s := w.h.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.writeErrs) > 0 {
+		err := s.writeErrs[0]
+		s.writeErrs = s.writeErrs[1:]
+		return err
+	}
+	b, ok := s.buckets[w.h.name]
+	if !ok {
+		return storage.ErrBucketNotExist
+	}
+	crc := crc32.Checksum(w.buf.Bytes(), crc32cTable)
+	if w.req.SendCRC32C && w.req.Attrs.CRC32C != crc {
+		return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf(
+			"Provided CRC32C %08x doesn't match calculated CRC32C %08x.", w.req.Attrs.CRC32C, crc)}
+	}
+	existing, exists := b.objects[w.req.Attrs.Name]
+	if conds := w.req.Conditions; conds != nil {
+		switch {
+		case conds.DoesNotExist && exists,
+			conds.GenerationMatch != 0 && (!exists || existing.Attrs.Generation != conds.GenerationMatch):
+			return preconditionFailed()
+		}
+	}
+
+	s.generation++
+	attrs := w.req.Attrs
+	attrs.Bucket = w.h.name
+	attrs.Size = int64(w.buf.Len())
+	attrs.CRC32C = crc
+	attrs.Generation = s.generation
+	attrs.Metageneration = 1
+	attrs.Created = time.Now()
+	attrs.Updated = attrs.Created
+	attrs.PredefinedACL = ""
+	if attrs.StorageClass == "" {
+		attrs.StorageClass = "STANDARD"
+	}
+	b.objects[attrs.Name] = &Object{Attrs: attrs, Data: bytes.Clone(w.buf.Bytes())}
+	w.attrs = &attrs
+	return nil
+}
+
+//Attrs returns the attributes of the object once Close succeeded.
+func (w *writer) Attrs() *storage.ObjectAttrs {
+	if w.attrs == nil {
+		return nil
+	}
+	attrs := *w.attrs
+	return &attrs
+}
+
+//preconditionFailed returns the error of a failed precondition.
+func preconditionFailed() error {
+	return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "At least one of the pre-conditions you specified did not hold."}
+}
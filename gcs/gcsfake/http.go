@@ -0,0 +1,157 @@
+package gcsfake
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	raw "google.golang.org/api/storage/v1"
+)
+
+//Handler returns an HTTP handler serving the objects of s over the part
+//of the GCS APIs outside the upload path that the client uses: listing
+//objects, reading their metadata and deleting them over the JSON API,
+//and downloading them over the XML API. Pointing the storage client of a
+//gcs.Client at it with option.WithEndpoint, as
+//
+//	httpServer := httptest.NewServer(server.Handler())
+//	sc, err := storage.NewClient(ctx, option.WithoutAuthentication(),
+//		option.WithEndpoint(httpServer.URL+"/storage/v1/"))
+//
+//lets operations such as Sync, Delete and Download run against s.
+//Listing supports prefixes but not delimiters or pages, and downloads
+//serve the stored bytes in full, without decompressing them.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/o", s.list)
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/o/{object...}", s.get)
+	mux.HandleFunc("DELETE /storage/v1/b/{bucket}/o/{object...}", s.delete)
+	mux.HandleFunc("GET /{bucket}/{object...}", s.download)
+	return mux
+}
+
+//download serves the data of an object with the headers of the XML API.
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	obj, ok := s.Object(r.PathValue("bucket"), r.PathValue("object"))
+	if !ok {
+		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		return
+	}
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], obj.Attrs.CRC32C)
+	h := w.Header()
+	h.Set("Content-Type", obj.Attrs.ContentType)
+	h.Set("Content-Length", strconv.Itoa(len(obj.Data)))
+	h.Set("Last-Modified", obj.Attrs.Updated.UTC().Format(http.TimeFormat))
+	h.Set("X-Goog-Generation", strconv.FormatInt(obj.Attrs.Generation, 10))
+	h.Set("X-Goog-Metageneration", strconv.FormatInt(obj.Attrs.Metageneration, 10))
+	h.Set("X-Goog-Hash", "crc32c="+base64.StdEncoding.EncodeToString(crc[:]))
+	if obj.Attrs.ContentEncoding != "" {
+		h.Set("Content-Encoding", obj.Attrs.ContentEncoding)
+		h.Set("X-Goog-Stored-Content-Encoding", obj.Attrs.ContentEncoding)
+	}
+	for key, value := range obj.Attrs.Metadata {
+		h.Set("X-Goog-Meta-"+key, value)
+	}
+	w.Write(obj.Data)
+}
+
+//list serves the objects of a bucket whose names start with the prefix
+//parameter.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, ok := s.buckets[r.PathValue("bucket")]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "The specified bucket does not exist.")
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	resp := raw.Objects{Kind: "storage#objects", Items: []*raw.Object{}}
+	for name, obj := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			resp.Items = append(resp.Items, rawObject(&obj.Attrs))
+		}
+	}
+	s.mu.Unlock()
+	slices.SortFunc(resp.Items, func(a, b *raw.Object) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	writeJSON(w, &resp)
+}
+
+//get serves the metadata of an object.
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	obj, ok := s.Object(r.PathValue("bucket"), r.PathValue("object"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such object.")
+		return
+	}
+	writeJSON(w, rawObject(&obj.Attrs))
+}
+
+//delete deletes an object, at the generation of the ifGenerationMatch
+//parameter if set.
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[r.PathValue("bucket")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "The specified bucket does not exist.")
+		return
+	}
+	name := r.PathValue("object")
+	obj, ok := b.objects[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such object.")
+		return
+	}
+	if match := r.URL.Query().Get("ifGenerationMatch"); match != "" && match != strconv.FormatInt(obj.Attrs.Generation, 10) {
+		writeError(w, http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+		return
+	}
+	delete(b.objects, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//rawObject returns attrs as the object resource of the JSON API.
+func rawObject(attrs *storage.ObjectAttrs) *raw.Object {
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], attrs.CRC32C)
+	return &raw.Object{
+		Kind:            "storage#object",
+		Name:            attrs.Name,
+		Bucket:          attrs.Bucket,
+		Size:            uint64(attrs.Size),
+		Generation:      attrs.Generation,
+		Metageneration:  attrs.Metageneration,
+		Crc32c:          base64.StdEncoding.EncodeToString(crc[:]),
+		ContentType:     attrs.ContentType,
+		ContentEncoding: attrs.ContentEncoding,
+		StorageClass:    attrs.StorageClass,
+		Metadata:        attrs.Metadata,
+		TimeCreated:     attrs.Created.Format(time.RFC3339Nano),
+		Updated:         attrs.Updated.Format(time.RFC3339Nano),
+	}
+}
+
+//writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+//writeError writes an error response of the JSON API.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,42 @@
+package gcs
+
+import "time"
+
+//Hooks are callbacks around the lifecycle of file and reader uploads,
+//including those of UploadDir and UploadAll, set with WithHooks.
+//Each is optional and runs synchronously on the uploading goroutine, so
+//it should return quickly; calls for concurrent uploads may overlap.
+type Hooks struct {
+	//OnStart is called when the upload of objectName starts sending data.
+	OnStart func(objectName string)
+
+	//OnComplete is called when objectName has been written, or skipped as
+	//unchanged, with the stored size of the object in bytes.
+	OnComplete func(objectName string, bytes int64, duration time.Duration)
+
+	//OnError is called when an upload fails, including failures before
+	//any data is sent such as a missing file, in which case OnStart is not
+	//called.
+	OnError func(objectName string, err error)
+}
+
+//start calls OnStart if set.
+func (h Hooks) start(objectName string) {
+	if h.OnStart != nil {
+		h.OnStart(objectName)
+	}
+}
+
+//done calls OnComplete or OnError, if set, for the outcome of an upload
+//that started at start.
+func (h Hooks) done(objectName string, result *UploadResult, err error, start time.Time) {
+	if err != nil {
+		if h.OnError != nil {
+			h.OnError(objectName, err)
+		}
+		return
+	}
+	if h.OnComplete != nil {
+		h.OnComplete(result.Name, result.Size, time.Since(start))
+	}
+}
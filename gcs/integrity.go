@@ -0,0 +1,40 @@
+package gcs
+
+import (
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+//crc32cTable is the Castagnoli table used by GCS for CRC32C checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+//ErrIntegrity is wrapped by uploads whose stored data does not match the
+//data that was sent.
+var ErrIntegrity = errors.New("object checksum mismatch")
+
+//encodedCRC32C returns the CRC32C of r encoded as writeObject would store
+//it with opts, i.e. of the compressed bytes when compressing.
+//Gzip output is deterministic for a given level, so the checksum can be
+//computed in a separate pass before uploading.
+func encodedCRC32C(r io.Reader, opts UploadOpts) (uint32, error) {
+	h := crc32.New(crc32cTable)
+	if _, err := encode(h, r, opts); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+//isChecksumRejection reports whether err is GCS rejecting a write because
+//it did not match the CRC32C sent with it.
+func isChecksumRejection(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusBadRequest {
+		return false
+	}
+	return strings.Contains(strings.ToLower(gerr.Message), "crc32c")
+}
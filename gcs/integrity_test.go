@@ -0,0 +1,37 @@
+package gcs_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+)
+
+//BenchmarkUploadSendCRC32C measures the cost of the checksum pass of
+//SendCRC32C, which reads and, when compressing, compresses the file twice.
+func BenchmarkUploadSendCRC32C(b *testing.B) {
+	data := bytes.Repeat([]byte("2024-01-01T00:00:00Z INFO request served in 12ms\n"), (1<<20)/50)
+	file := writeFile(b, "data.log", data)
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(b, server)
+
+	for _, compress := range []gcs.CompressMode{gcs.CompressNever, gcs.CompressAlways} {
+		for _, send := range []bool{false, true} {
+			name := fmt.Sprintf("compress=%t/crc32c=%t", compress == gcs.CompressAlways, send)
+			b.Run(name, func(b *testing.B) {
+				opts := gcs.UploadOpts{Compress: compress, SendCRC32C: send}
+				b.ReportAllocs()
+				b.SetBytes(int64(len(data)))
+				for b.Loop() {
+					if _, err := client.UploadWithOptions(context.Background(), "bucket", file, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"cloud.google.com/go/storage"
+)
+
+//UploadLarge writes file to GCS bucket as objectName in parts of partSize
+//bytes, uploading up to concurrency parts at a time and composing them
+//server-side, like the parallel composite uploads of gsutil
+// - This speeds up multi-gigabyte uploads on links a single stream cannot
+//saturate.
+// - The data is stored uncompressed, with the content type detected as in
+//Upload.
+// - Parts are written to temporary objects named after objectName and a
+//random token, so that concurrent uploads to objectName do not share
+//them. They are deleted once composed or if the upload fails, at the
+//generations written only, so existing objects are never touched.
+func (c *Client) UploadLarge(bucket string, objectName string, filename string, partSize int64, concurrency int) error {
+	if partSize <= 0 {
+		return fmt.Errorf("invalid part size %d, must be positive", partSize)
+	}
+	opts := c.withDefaults(UploadOpts{})
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	handle, err := c.useBucket(ctx, bucket, opts)
+	if err != nil {
+		c.log.Error("GCS: Error setting bucket", "bucket", bucket, "err", err)
+		return err
+	}
+
+	f, info, err := c.openLocalFile(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opts.ContentType, _ = detectContentType(filename, io.NewSectionReader(f, 0, info.Size()))
+
+	n := int((info.Size() + partSize - 1) / partSize)
+	if n == 0 {
+		n = 1
+	}
+	token := tempToken()
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = fmt.Sprintf("%s.part-%s-%d", objectName, token, i)
+	}
+	//DoesNotExist keeps an object that happens to have the name of a part.
+	opts.DoesNotExist = true
+	var temps []tempObject
+	defer c.deleteTemps(context.WithoutCancel(ctx), c.storageBucket(bucket), &temps, "part")
+
+	//The first failure cancels the parts not yet started or in flight.
+	var mu sync.Mutex
+	var firstErr error
+	failed := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	written := func(attrs *storage.ObjectAttrs) {
+		mu.Lock()
+		defer mu.Unlock()
+		if attrs != nil && !c.dryRun {
+			temps = append(temps, tempObject{attrs.Name, attrs.Generation})
+		}
+	}
+
+	c.log.Info("GCS: Uploading object in parts", "object", objectName, "parts", n)
+	forEach(ctx, n, concurrency, func(i int) {
+		part := io.NewSectionReader(f, int64(i)*partSize, partSize)
+		_, attrs, err := c.writeObject(ctx, handle, parts[i], localFile{part}, opts, nil)
+		if err != nil {
+			failed(err)
+			return
+		}
+		written(attrs)
+	}, func(i int, err error) {
+		failed(err)
+	})
+	if firstErr != nil {
+		return firstErr
+	}
+
+	return c.ComposeWithOptions(ctx, bucket, parts, objectName, ComposeOpts{ContentType: opts.ContentType})
+}
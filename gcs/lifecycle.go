@@ -0,0 +1,97 @@
+package gcs
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+//LifecycleRule is a bucket lifecycle rule: objects at least AgeDays old,
+//and whose names start with one of MatchesPrefix if set, are deleted or,
+//if StorageClass is set, moved to that storage class.
+type LifecycleRule struct {
+	//AgeDays is the age in days from which the rule applies to an object.
+	//It must be positive: GCS drops an age of zero, which would leave the
+	//rule without a condition.
+	AgeDays int
+
+	//StorageClass is the class matching objects are moved to, one of
+	//"NEARLINE", "COLDLINE" or "ARCHIVE". When empty they are deleted.
+	StorageClass string
+
+	//MatchesPrefix restricts the rule to objects whose names start with
+	//one of the prefixes. When empty the rule applies to every object.
+	MatchesPrefix []string
+}
+
+//transitionClasses are the storage classes objects can be moved to.
+var transitionClasses = map[string]bool{
+	"NEARLINE": true,
+	"COLDLINE": true,
+	"ARCHIVE":  true,
+}
+
+//DeleteAfter returns a rule deleting objects days old.
+func DeleteAfter(days int) LifecycleRule {
+	return LifecycleRule{AgeDays: days}
+}
+
+//TransitionAfter returns a rule moving objects days old to storageClass.
+func TransitionAfter(days int, storageClass string) LifecycleRule {
+	return LifecycleRule{AgeDays: days, StorageClass: storageClass}
+}
+
+//validate reports an error for invalid rules.
+func (r LifecycleRule) validate() error {
+	if r.AgeDays <= 0 {
+		return fmt.Errorf("invalid lifecycle age %d days, must be positive", r.AgeDays)
+	}
+	if r.StorageClass != "" && !transitionClasses[r.StorageClass] {
+		return fmt.Errorf("invalid lifecycle storage class %q, want NEARLINE, COLDLINE or ARCHIVE", r.StorageClass)
+	}
+	return nil
+}
+
+//rule returns r as a storage.LifecycleRule.
+func (r LifecycleRule) rule() storage.LifecycleRule {
+	action := storage.LifecycleAction{Type: storage.DeleteAction}
+	if r.StorageClass != "" {
+		action = storage.LifecycleAction{Type: storage.SetStorageClassAction, StorageClass: r.StorageClass}
+	}
+	return storage.LifecycleRule{
+		Action: action,
+		Condition: storage.LifecycleCondition{
+			AgeInDays:     int64(r.AgeDays),
+			MatchesPrefix: r.MatchesPrefix,
+		},
+	}
+}
+
+//SetLifecycle replaces the lifecycle rules of a GCS bucket with rules,
+//e.g. SetLifecycle(bucket, TransitionAfter(7, "COLDLINE"), DeleteAfter(30))
+// - Calling it without rules removes every lifecycle rule.
+// - GCS applies rules asynchronously, typically within a day.
+func (c *Client) SetLifecycle(bucket string, rules ...LifecycleRule) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	lifecycle := &storage.Lifecycle{}
+	for _, r := range rules {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		lifecycle.Rules = append(lifecycle.Rules, r.rule())
+	}
+	if c.skipDryRun("setting lifecycle rules", "bucket", bucket, "rules", len(rules)) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	_, err := c.storageBucket(bucket).Update(ctx, storage.BucketAttrsToUpdate{Lifecycle: lifecycle})
+	if err != nil {
+		c.log.Error("GCS: Error setting lifecycle rules", "bucket", bucket, "err", err)
+		return gcsError(bucket, "", err)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package gcs
+
+import "testing"
+
+func TestLifecycleRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule  LifecycleRule
+		valid bool
+	}{
+		{DeleteAfter(30), true},
+		{TransitionAfter(7, "NEARLINE"), true},
+		{TransitionAfter(30, "COLDLINE"), true},
+		{TransitionAfter(365, "ARCHIVE"), true},
+		{LifecycleRule{AgeDays: 1, MatchesPrefix: []string{"logs/"}}, true},
+
+		{DeleteAfter(0), false},
+		{DeleteAfter(-1), false},
+		{TransitionAfter(0, "COLDLINE"), false},
+		{TransitionAfter(7, "STANDARD"), false},
+		{TransitionAfter(7, "coldline"), false},
+		{TransitionAfter(7, "GLACIER"), false},
+	}
+	for _, tt := range tests {
+		err := tt.rule.validate()
+		if tt.valid && err != nil {
+			t.Errorf("validate(%+v) = %v, want nil", tt.rule, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("validate(%+v) = nil, want an error", tt.rule)
+		}
+	}
+}
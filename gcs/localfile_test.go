@@ -0,0 +1,60 @@
+package gcs_test
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+)
+
+func TestUploadLocalFileErrors(t *testing.T) {
+	unreadable := writeFile(t, "unreadable.txt", []byte("data"))
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	//Root and Windows ignore the mode, so the file stays readable.
+	canTestPermission := runtime.GOOS != "windows" && os.Geteuid() != 0
+
+	tests := []struct {
+		name  string
+		file  string
+		cause error
+	}{
+		{"not exist", filepath.Join(t.TempDir(), "missing.txt"), fs.ErrNotExist},
+		{"permission", unreadable, fs.ErrPermission},
+		{"directory", t.TempDir(), syscall.EISDIR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cause == fs.ErrPermission && !canTestPermission {
+				t.Skip("file modes are not enforced")
+			}
+			server := gcsfake.New()
+			server.CreateBucket("bucket")
+			client := newTestClient(t, server)
+
+			uploads := map[string]error{
+				"UploadWithOptions": func() error {
+					_, err := client.UploadWithOptions(context.Background(), "bucket", tt.file, gcs.UploadOpts{})
+					return err
+				}(),
+				"UploadLarge": client.UploadLarge("bucket", "large", tt.file, 1<<20, 2),
+			}
+			for upload, err := range uploads {
+				if !errors.Is(err, gcs.ErrLocalFile) || !errors.Is(err, tt.cause) {
+					t.Errorf("%s() = %v, want ErrLocalFile and %v", upload, err, tt.cause)
+				}
+			}
+			if names := server.ObjectNames("bucket"); len(names) != 0 {
+				t.Errorf("objects = %q, want none", names)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package gcs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+//manifestVersion is the version of the manifest format.
+const manifestVersion = 1
+
+//manifest is the JSON document written by WriteManifest.
+type manifest struct {
+	Version int              `json:"version"`
+	Objects []manifestObject `json:"objects"`
+}
+
+//manifestObject is the entry of one object in a manifest.
+type manifestObject struct {
+	URI        string `json:"uri"`
+	Bucket     string `json:"bucket"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	CRC32C     string `json:"crc32c"`
+	Generation int64  `json:"generation"`
+}
+
+//WriteManifest writes a JSON manifest of the objects in results to w, so
+//that consumers of a batch can verify they received every object intact.
+//Nil results, such as those of failed uploads, are left out.
+//The format is stable; fields may be added but none are removed:
+//
+//	{
+//	  "version": 1,
+//	  "objects": [
+//	    {
+//	      "uri": "gs://bucket/logs/a.json",
+//	      "bucket": "bucket",
+//	      "name": "logs/a.json",
+//	      "size": 1234,
+//	      "crc32c": "1a2b3c4d",
+//	      "generation": 1700000000000000
+//	    }
+//	  ]
+//	}
+//
+//size is the stored size in bytes and crc32c the CRC32C (Castagnoli) of
+//the stored bytes as 8 lowercase hex digits, both as GCS reports them.
+func WriteManifest(w io.Writer, results []*UploadResult) error {
+	m := manifest{Version: manifestVersion, Objects: []manifestObject{}}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		m.Objects = append(m.Objects, manifestObject{
+			URI:        r.URI,
+			Bucket:     r.Bucket,
+			Name:       r.Name,
+			Size:       r.Size,
+			CRC32C:     fmt.Sprintf("%08x", r.CRC32C),
+			Generation: r.Generation,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(m)
+}
+
+//UploadManifest writes the manifest of results, as by WriteManifest, to
+//objectName in GCS bucket, uncompressed with content type
+//'application/json'.
+func (c *Client) UploadManifest(bucket string, objectName string, results []*UploadResult) (*UploadResult, error) {
+	var buf bytes.Buffer
+	if err := WriteManifest(&buf, results); err != nil {
+		return nil, err
+	}
+	return c.UploadReaderWithOptions(c.ctx, bucket, objectName, &buf, UploadOpts{ContentType: "application/json"})
+}
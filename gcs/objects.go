@@ -0,0 +1,537 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+//ErrObjectNotFound is wrapped by operations on an object that does not
+//exist. The underlying storage.ErrObjectNotExist is wrapped as well.
+var ErrObjectNotFound = errors.New("object not found")
+
+//objectNotFound wraps err, which must be storage.ErrObjectNotExist, with
+//ErrObjectNotFound and the object location.
+func objectNotFound(bucket string, objectName string, err error) error {
+	return fmt.Errorf("%w: gs://%s/%s: %w", ErrObjectNotFound, bucket, objectName, err)
+}
+
+//Delete removes an object from a GCS bucket
+// - Returns an error wrapping ErrObjectNotFound if the object does not exist.
+func (c *Client) Delete(bucket string, objectName string) error {
+	return c.deleteObject(c.ctx, bucket, objectName)
+}
+
+//deleteObject is Delete running under ctx.
+func (c *Client) deleteObject(ctx context.Context, bucket string, objectName string) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+
+	if c.skipDryRun("deleting object", "bucket", bucket, "object", objectName) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	err := c.storageBucket(bucket).Object(objectName).Delete(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error deleting object", "object", objectName, "err", err)
+		return gcsError(bucket, objectName, err)
+	}
+	return nil
+}
+
+//DeletePrefix removes every object whose name starts with prefix, such as
+//all objects of the virtual folder 'logs/2024/'
+// - prefix must not be empty, to avoid emptying a whole bucket by mistake.
+// - Objects removed concurrently by someone else are not an error.
+func (c *Client) DeletePrefix(bucket string, prefix string) error {
+	if prefix == "" {
+		return errors.New("DeletePrefix requires a non-empty prefix")
+	}
+
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	b := c.storageBucket(bucket)
+	return c.listFunc(ctx, bucket, prefix, func(objectName string) error {
+		if c.skipDryRun("deleting object", "bucket", bucket, "object", objectName) {
+			return nil
+		}
+		if err := c.wait(ctx); err != nil {
+			return err
+		}
+		err := b.Object(objectName).Delete(ctx)
+		if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			c.log.Error("GCS: Error deleting object", "object", objectName, "err", err)
+			return gcsError(bucket, objectName, err)
+		}
+		return nil
+	})
+}
+
+//List returns the names of the objects in a GCS bucket whose names start
+//with prefix; an empty prefix lists the whole bucket
+// - Results are paged through transparently.
+// - For large buckets use ListFunc to avoid holding every name in memory.
+func (c *Client) List(bucket string, prefix string) ([]string, error) {
+	var names []string
+	err := c.ListFunc(bucket, prefix, func(objectName string) error {
+		names = append(names, objectName)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+//ListFunc calls fn with the name of each object in a GCS bucket whose
+//name starts with prefix, in lexicographic order
+// - If fn returns an error, listing stops and that error is returned.
+func (c *Client) ListFunc(bucket string, prefix string, fn func(objectName string) error) error {
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	return c.listFunc(ctx, bucket, prefix, fn)
+}
+
+//listFunc is ListFunc running under ctx.
+func (c *Client) listFunc(ctx context.Context, bucket string, prefix string, fn func(objectName string) error) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return err
+	}
+
+	it := c.storageBucket(bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			c.log.Error("GCS: Error listing objects", "bucket", bucket, "err", err)
+			return gcsError(bucket, "", err)
+		}
+		if err := fn(attrs.Name); err != nil {
+			return err
+		}
+	}
+}
+
+//Walk calls fn with the description of each object in a GCS bucket whose
+//name starts with prefix, in lexicographic order, fetching them page by
+//page so that memory use does not grow with the bucket
+// - If fn returns fs.SkipAll, walking stops and Walk returns nil; for any
+//other error it stops and that error is returned.
+// - Size is the stored size, as for GetAttrs.
+func (c *Client) Walk(bucket string, prefix string, fn func(ObjectInfo) error) error {
+	return c.WalkContext(c.ctx, bucket, prefix, fn)
+}
+
+//WalkContext is like Walk but runs under ctx. Walking stops with the
+//error of ctx once it is done, even within a page already fetched.
+func (c *Client) WalkContext(ctx context.Context, bucket string, prefix string, fn func(ObjectInfo) error) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	it := c.storageBucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			c.log.Error("GCS: Error listing objects", "bucket", bucket, "err", err)
+			return gcsError(bucket, "", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(*newObjectInfo(attrs)); err != nil {
+			if err == fs.SkipAll {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+//ListDir lists the virtual folder prefix of a GCS bucket like a
+//directory: it returns the names of the objects directly in it and the
+//common prefixes of the subfolders, each ending in '/', as the GCS
+//console shows them
+// - prefix names a folder, so 'logs' and 'logs/' both list the contents
+//of 'logs/' rather than every name starting with 'logs'. An empty prefix
+//lists the top level of the bucket.
+func (c *Client) ListDir(bucket string, prefix string) (objects []string, folders []string, err error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	query := &storage.Query{Prefix: prefix, Delimiter: "/"}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, nil, err
+	}
+
+	it := c.storageBucket(bucket).Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, folders, nil
+		}
+		if err != nil {
+			c.log.Error("GCS: Error listing objects", "bucket", bucket, "err", err)
+			return nil, nil, gcsError(bucket, "", err)
+		}
+		if attrs.Prefix != "" {
+			folders = append(folders, attrs.Prefix)
+		} else if attrs.Name != prefix {
+			//The placeholder object of the folder itself, as created by
+			//the console, is not part of its contents.
+			objects = append(objects, attrs.Name)
+		}
+	}
+}
+
+//ListPage returns one page of up to pageSize names of the objects in a
+//GCS bucket whose names start with prefix, for paging through large
+//buckets without holding every name
+// - pageToken is empty for the first page, and the nextToken returned by
+//the previous call for the following ones.
+// - nextToken is empty once the listing is exhausted.
+func (c *Client) ListPage(ctx context.Context, bucket string, prefix string, pageToken string, pageSize int) (names []string, nextToken string, err error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, "", err
+	}
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("invalid page size %d, must be positive", pageSize)
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	query := &storage.Query{Prefix: prefix}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, "", err
+	}
+
+	var page []*storage.ObjectAttrs
+	it := c.storageBucket(bucket).Objects(ctx, query)
+	nextToken, err = iterator.NewPager(it, pageSize, pageToken).NextPage(&page)
+	if err != nil {
+		c.log.Error("GCS: Error listing objects", "bucket", bucket, "err", err)
+		return nil, "", gcsError(bucket, "", err)
+	}
+	names = make([]string, len(page))
+	for i, attrs := range page {
+		names[i] = attrs.Name
+	}
+	return names, nextToken, nil
+}
+
+//DeleteGeneration removes the given generation of an object from a GCS
+//bucket with versioning, such as a noncurrent version listed by
+//ListVersions
+// - Returns an error wrapping ErrObjectNotFound if the generation does not
+//exist.
+func (c *Client) DeleteGeneration(bucket string, objectName string, generation int64) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	if c.skipDryRun("deleting object", "bucket", bucket, "object", objectName, "generation", generation) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+
+	err := c.storageBucket(bucket).Object(objectName).Generation(generation).Delete(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error deleting object", "object", objectName, "generation", generation, "err", err)
+		return gcsError(bucket, objectName, err)
+	}
+	return nil
+}
+
+//ListVersions returns every generation, live and noncurrent, of the
+//objects in a GCS bucket whose names start with prefix
+// - Results are ordered by name, then by generation, oldest first.
+// - Generation identifies each version for DownloadGeneration and
+//DeleteGeneration.
+func (c *Client) ListVersions(bucket string, prefix string) ([]*ObjectInfo, error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	var versions []*ObjectInfo
+	it := c.storageBucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix, Versions: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return versions, nil
+		}
+		if err != nil {
+			c.log.Error("GCS: Error listing object versions", "bucket", bucket, "err", err)
+			return nil, gcsError(bucket, "", err)
+		}
+		versions = append(versions, newObjectInfo(attrs))
+	}
+}
+
+//ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Name            string
+	Bucket          string
+	Size            int64
+	ContentType     string
+	ContentEncoding string
+	StorageClass    string
+	Generation      int64
+	CRC32C          uint32
+	Metadata        map[string]string
+	Created         time.Time
+	Updated         time.Time
+}
+
+//newObjectInfo builds an ObjectInfo from the attributes GCS returned for
+//an object.
+func newObjectInfo(attrs *storage.ObjectAttrs) *ObjectInfo {
+	return &ObjectInfo{
+		Name:            attrs.Name,
+		Bucket:          attrs.Bucket,
+		Size:            attrs.Size,
+		ContentType:     attrs.ContentType,
+		ContentEncoding: attrs.ContentEncoding,
+		StorageClass:    attrs.StorageClass,
+		Generation:      attrs.Generation,
+		CRC32C:          attrs.CRC32C,
+		Metadata:        attrs.Metadata,
+		Created:         attrs.Created,
+		Updated:         attrs.Updated,
+	}
+}
+
+//GetAttrs returns the attributes of an object in a GCS bucket without
+//downloading it
+// - Size is the stored size, i.e. the compressed size of objects
+//compressed by Upload.
+// - Returns an error wrapping ErrObjectNotFound if the object does not exist.
+func (c *Client) GetAttrs(bucket string, objectName string) (*ObjectInfo, error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	attrs, err := c.storageBucket(bucket).Object(objectName).Attrs(ctx)
+	if err != nil {
+		c.log.Error("GCS: Error reading object attributes", "object", objectName, "err", err)
+		return nil, gcsError(bucket, objectName, err)
+	}
+	return newObjectInfo(attrs), nil
+}
+
+//OriginalFilenameKey is the metadata key under which file uploads record
+//the base name of the uploaded file, e.g. 'data.json' for the object
+//'logs/data.json.gz'.
+const OriginalFilenameKey = "original-filename"
+
+//withOriginalFilename returns metadata with the base name of filename
+//recorded under OriginalFilenameKey, unless already set. metadata is not
+//modified.
+func withOriginalFilename(metadata map[string]string, filename string) map[string]string {
+	if _, ok := metadata[OriginalFilenameKey]; ok {
+		return metadata
+	}
+	m := maps.Clone(metadata)
+	if m == nil {
+		m = map[string]string{}
+	}
+	m[OriginalFilenameKey] = filepath.Base(filename)
+	return m
+}
+
+//OriginalFilename returns the name of the file objectName in a GCS
+//bucket was uploaded from, as recorded under OriginalFilenameKey, or ""
+//if the object was not uploaded from a file.
+// - Returns an error wrapping ErrObjectNotFound if the object does not exist.
+func (c *Client) OriginalFilename(bucket string, objectName string) (string, error) {
+	info, err := c.GetAttrs(bucket, objectName)
+	if err != nil {
+		return "", err
+	}
+	return info.Metadata[OriginalFilenameKey], nil
+}
+
+//Exists reports whether objectName exists in a GCS bucket. Errors other
+//than the object not existing, such as a denied permission or a missing
+//bucket, are returned.
+func (c *Client) Exists(bucket string, objectName string) (bool, error) {
+	if err := c.checkOpen(); err != nil {
+		return false, err
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	_, err := c.storageBucket(bucket).Object(objectName).Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		c.log.Error("GCS: Error reading object attributes", "object", objectName, "err", err)
+		return false, gcsError(bucket, objectName, err)
+	}
+	return true, nil
+}
+
+//UpdateMetadata changes the content type and custom metadata of an
+//existing object in a GCS bucket without re-uploading it
+// - An empty contentType leaves the content type unchanged.
+// - metadata is merged into the existing metadata: keys set to an empty
+//value are deleted and keys not in metadata are kept.
+// - Returns an error wrapping ErrObjectNotFound if the object does not exist.
+func (c *Client) UpdateMetadata(bucket string, objectName string, contentType string, metadata map[string]string) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	for k := range metadata {
+		if k == "" {
+			return errors.New("invalid metadata, keys must not be empty")
+		}
+	}
+	if c.skipDryRun("updating object", "bucket", bucket, "object", objectName) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	var update storage.ObjectAttrsToUpdate
+	if contentType != "" {
+		update.ContentType = contentType
+	}
+	if len(metadata) > 0 {
+		update.Metadata = metadata
+	}
+	_, err := c.storageBucket(bucket).Object(objectName).Update(ctx, update)
+	if err != nil {
+		c.log.Error("GCS: Error updating object", "object", objectName, "err", err)
+		return gcsError(bucket, objectName, err)
+	}
+	return nil
+}
+
+//UploadResult describes an object written by an upload.
+type UploadResult struct {
+	Name       string
+	Bucket     string
+	Generation int64
+	CRC32C     uint32
+	Metadata   map[string]string
+
+	//Size is the stored size of the object, i.e. the compressed size when
+	//compressed, and SourceSize the number of bytes read from the source
+	//file or reader, so that Size / SourceSize is the compression ratio.
+	Size       int64
+	SourceSize int64
+
+	//URI is the gs:// URI of the object.
+	URI string
+	//URL is the https URL of the object. It is only reachable without
+	//credentials if the object is publicly readable.
+	URL string
+
+	//Skipped is set if the upload was skipped because an identical object
+	//already existed.
+	Skipped bool
+}
+
+//newUploadResult builds an UploadResult from the attributes GCS returned
+//for a new object.
+func newUploadResult(attrs *storage.ObjectAttrs) *UploadResult {
+	return &UploadResult{
+		Name:       attrs.Name,
+		Bucket:     attrs.Bucket,
+		Size:       attrs.Size,
+		Generation: attrs.Generation,
+		CRC32C:     attrs.CRC32C,
+		Metadata:   attrs.Metadata,
+		URI:        ObjectURI(attrs.Bucket, attrs.Name),
+		URL:        PublicURL(attrs.Bucket, attrs.Name),
+	}
+}
+
+//ObjectURI returns the gs:// URI of objectName in bucket, as used by
+//gsutil and other GCS tools, with the name escaped as by PublicURL.
+func ObjectURI(bucket string, objectName string) string {
+	return "gs://" + bucket + "/" + escapeObjectName(objectName)
+}
+
+//PublicURL returns the https URL of objectName in bucket, with the name
+//escaped but its '/' separators kept. It is only reachable without
+//credentials if the object is publicly readable.
+func PublicURL(bucket string, objectName string) string {
+	return "https://storage.googleapis.com/" + bucket + "/" + escapeObjectName(objectName)
+}
+
+//escapeObjectName escapes objectName for use in a URL path, keeping the
+//'/' separators of virtual folders.
+func escapeObjectName(objectName string) string {
+	return strings.ReplaceAll(url.PathEscape(objectName), "%2F", "/")
+}
+
+//maxSignedURLTTL is the longest validity GCS allows for V4 signed URLs.
+const maxSignedURLTTL = 7 * 24 * time.Hour
+
+//SignedURL returns a V4 signed URL that allows anyone holding it to
+//download objectName from a GCS bucket for ttl, at most 7 days
+// - It is signed with the credentials the client was created with. A
+//service account key signs locally; credentials without a private key,
+//such as those of the metadata server, sign through the IAM signBlob API
+//and need the iam.serviceAccounts.signBlob permission.
+func (c *Client) SignedURL(bucket string, objectName string, ttl time.Duration) (string, error) {
+	if err := c.checkOpen(); err != nil {
+		return "", err
+	}
+	if ttl <= 0 || ttl > maxSignedURLTTL {
+		return "", fmt.Errorf("invalid signed URL ttl %s, must be positive and at most %s", ttl, maxSignedURLTTL)
+	}
+
+	u, err := c.storageBucket(bucket).SignedURL(objectName, &storage.SignedURLOptions{
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(ttl),
+		Scheme:  storage.SigningSchemeV4,
+	})
+	if err != nil {
+		c.log.Error("GCS: Error signing URL", "object", objectName, "err", err)
+		return "", fmt.Errorf("unable to sign URL for gs://%s/%s; credentials without a private key "+
+			"must be allowed to sign through IAM (iam.serviceAccounts.signBlob): %w", bucket, objectName, err)
+	}
+	return u, nil
+}
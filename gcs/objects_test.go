@@ -0,0 +1,24 @@
+package gcs
+
+import "testing"
+
+func TestObjectURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		url  string
+	}{
+		{"data.json", "gs://bucket/data.json", "https://storage.googleapis.com/bucket/data.json"},
+		{"logs/2024/app.log", "gs://bucket/logs/2024/app.log", "https://storage.googleapis.com/bucket/logs/2024/app.log"},
+		{"my file#1?.txt", "gs://bucket/my%20file%231%3F.txt", "https://storage.googleapis.com/bucket/my%20file%231%3F.txt"},
+		{"café/naïve", "gs://bucket/caf%C3%A9/na%C3%AFve", "https://storage.googleapis.com/bucket/caf%C3%A9/na%C3%AFve"},
+	}
+	for _, tt := range tests {
+		if got := ObjectURI("bucket", tt.name); got != tt.uri {
+			t.Errorf("ObjectURI(%q) = %q, want %q", tt.name, got, tt.uri)
+		}
+		if got := PublicURL("bucket", tt.name); got != tt.url {
+			t.Errorf("PublicURL(%q) = %q, want %q", tt.name, got, tt.url)
+		}
+	}
+}
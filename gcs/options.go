@@ -0,0 +1,277 @@
+package gcs
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/time/rate"
+)
+
+//Option configures a Client created by New or Connect.
+type Option func(*config)
+
+//config holds the settings gathered from Options.
+type config struct {
+	projectID        string
+	credentialsFile  string
+	credentialsJSON  []byte
+	endpoint         string
+	ctx              context.Context
+	logger           *slog.Logger
+	compressionLevel int
+	dryRun           bool
+	opTimeout        time.Duration
+	rateLimit        rate.Limit
+	rateBurst        int
+	bandwidth        int
+	hooks            Hooks
+	userProject      string
+	createBuckets    bool
+	retryDeadline    time.Duration
+	client           *storage.Client
+	compressedSuffix string
+	bucketFactory    func(name string) Bucket
+
+	impersonate       string
+	impersonateScopes []string
+
+	//errs are the errors of invalid Options, returned by New.
+	errs []error
+}
+
+//defaultConfig returns the settings used when no Option overrides them.
+func defaultConfig() config {
+	return config{
+		ctx:              context.Background(),
+		logger:           slog.New(slog.DiscardHandler),
+		compressionLevel: gzip.DefaultCompression,
+	}
+}
+
+//WithProjectID sets the project the client works in.
+//It defaults to the GOOGLE_CLOUD_PROJECT environment var.
+func WithProjectID(projectID string) Option {
+	return func(c *config) {
+		c.projectID = projectID
+	}
+}
+
+//WithCredentialsFile authenticates with the credentials JSON file
+//filename instead of Application Default Credentials.
+func WithCredentialsFile(filename string) Option {
+	return func(c *config) {
+		c.credentialsFile = filename
+	}
+}
+
+//WithCredentialsJSON authenticates with the credentials JSON credJSON,
+//such as a service account key held in memory, instead of Application
+//Default Credentials. It takes precedence over WithCredentialsFile.
+func WithCredentialsJSON(credJSON []byte) Option {
+	return func(c *config) {
+		c.credentialsJSON = credJSON
+	}
+}
+
+//WithImpersonatedServiceAccount has the client act as the service
+//account email with short-lived tokens, authenticated by the credentials
+//the client would otherwise use, which need the Service Account Token
+//Creator role on email. scopes default to full control of GCS.
+//New fails if the impersonation cannot be set up.
+func WithImpersonatedServiceAccount(email string, scopes []string) Option {
+	return func(c *config) {
+		c.impersonate = email
+		c.impersonateScopes = scopes
+	}
+}
+
+//WithEndpoint sends requests to the GCS endpoint rawURL instead of the
+//default one, e.g. a Private Service Connect endpoint or an emulator
+//such as 'http://localhost:4443/storage/v1/'. New fails if rawURL is not
+//an absolute http or https URL.
+func WithEndpoint(rawURL string) Option {
+	return func(c *config) {
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.errs = append(c.errs, fmt.Errorf("invalid endpoint %q, want an absolute http or https URL", rawURL))
+			return
+		}
+		c.endpoint = rawURL
+	}
+}
+
+//WithContext sets the base context of the client. storage.NewClient and
+//the credential lookup run under it, and so do operations without a
+//context argument, such as Upload or Delete, so its cancellation,
+//deadline and values such as trace IDs carry into their GCS calls.
+//Operations taking a context, such as UploadWithOptions, use theirs
+//instead. It defaults to context.Background().
+func WithContext(ctx context.Context) Option {
+	return func(c *config) {
+		if ctx != nil {
+			c.ctx = ctx
+		}
+	}
+}
+
+//WithLogger routes the diagnostic output of the client to logger.
+//By default a client logs nothing.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config) {
+		if logger != nil {
+			c.logger = logger
+		}
+	}
+}
+
+//WithCompressionLevel sets the gzip level used by uploads whose
+//UploadOpts.CompressionLevel is zero, from gzip.HuffmanOnly to
+//gzip.BestCompression. It defaults to gzip.DefaultCompression.
+func WithCompressionLevel(level int) Option {
+	return func(c *config) {
+		c.compressionLevel = level
+	}
+}
+
+//WithDryRun makes the client log the objects it would write or delete and
+//the buckets it would create, and report success without changing
+//anything in GCS. Local work still happens, e.g. files are read and
+//compressed, so errors such as missing files surface; reads such as List
+//still go to GCS.
+func WithDryRun() Option {
+	return func(c *config) {
+		c.dryRun = true
+	}
+}
+
+//WithOperationTimeout bounds each operation of the client, such as an
+//upload, download or delete, including its retries, to timeout. An upload
+//that times out is aborted, so no partial object is committed. Batch
+//operations such as UploadDir and UploadAll bound each file; ListFunc and
+//DeletePrefix are bounded as a whole. Zero, the default, means no timeout.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.opTimeout = timeout
+	}
+}
+
+//WithUserProject bills requests to projectID, as requester-pays buckets
+//require; without it every request to such a bucket fails with a 400.
+//It applies to all buckets the client uses.
+func WithUserProject(projectID string) Option {
+	return func(c *config) {
+		c.userProject = projectID
+	}
+}
+
+//WithBucketCreation lets every upload of the client create its bucket if
+//it does not exist, as if BucketOpts.CreateIfMissing were set, with the
+//attributes of the upload's BucketOpts. Without it, the default, uploads
+//to a missing bucket fail with ErrBucketNotFound unless they set
+//CreateIfMissing, so that a mistyped name never provisions a bucket.
+func WithBucketCreation() Option {
+	return func(c *config) {
+		c.createBuckets = true
+	}
+}
+
+//WithRetryDeadline bounds the retries of every operation of the client
+//that does not set RetryPolicy.Deadline to a total of deadline, including
+//the delays between attempts. Unlike WithOperationTimeout it only bounds
+//the retried steps, and exhausting it returns the last error wrapped
+//with a message saying so.
+func WithRetryDeadline(deadline time.Duration) Option {
+	return func(c *config) {
+		c.retryDeadline = deadline
+	}
+}
+
+//WithStorageClient makes New adopt client instead of creating its own
+//storage client, e.g. to reuse one configured with a custom HTTP client,
+//or a test double. No credentials are looked up and the options setting
+//credentials, impersonation or the endpoint cannot be combined with it.
+//client is not closed by Client.Close.
+func WithStorageClient(client *storage.Client) Option {
+	return func(c *config) {
+		c.client = client
+	}
+}
+
+//WithBucketFactory makes uploads write through the buckets returned by
+//factory instead of the storage client, e.g. the in-memory buckets of
+//package gcsfake to test code uploading with the client without GCS.
+//factory is called with the bucket name on every upload. Operations other
+//than uploads still use the storage client; combine it with
+//WithStorageClient to avoid looking up credentials.
+func WithBucketFactory(factory func(name string) Bucket) Option {
+	return func(c *config) {
+		if factory == nil {
+			c.errs = append(c.errs, errors.New("nil bucket factory"))
+			return
+		}
+		c.bucketFactory = factory
+	}
+}
+
+//WithCompressedSuffix sets the suffix appended to derived names of the
+//objects the client compresses, such as '.gz' so that standard tooling
+//recognizes them; by default names are kept as is. Uploads can override
+//it with UploadOpts.CompressedSuffix. Changing it changes object names:
+//Sync uploads existing files again under the new names, and with
+//SyncOpts.Delete removes the objects with the previous suffix.
+//New fails if suffix is neither empty nor an extension starting with '.'.
+func WithCompressedSuffix(suffix string) Option {
+	return func(c *config) {
+		if err := validateCompressedSuffix(suffix); err != nil {
+			c.errs = append(c.errs, err)
+			return
+		}
+		c.compressedSuffix = suffix
+	}
+}
+
+//WithRateLimit limits the client to opsPerSecond uploads, composes and
+//deletes, allowing bursts of up to burst operations, to stay under the
+//GCS per-bucket write rate instead of triggering 429 responses. Every
+//attempt counts, so retries are limited too, and so do the writes and
+//deletes of the temporary objects of Compose and UploadLarge. Operations wait for their
+//turn until their context is done. By default there is no limit.
+func WithRateLimit(opsPerSecond float64, burst int) Option {
+	return func(c *config) {
+		if opsPerSecond <= 0 || burst < 1 {
+			c.errs = append(c.errs, fmt.Errorf("invalid rate limit %g/s with burst %d, both must be positive", opsPerSecond, burst))
+			return
+		}
+		c.rateLimit = rate.Limit(opsPerSecond)
+		c.rateBurst = burst
+	}
+}
+
+//WithBandwidthLimit caps the rate at which the client sends object data
+//to GCS to bytesPerSecond, shared by all of its uploads, so that large
+//transfers do not saturate a shared link. The limit applies to the bytes
+//sent, i.e. to the compressed bytes of compressed uploads. By default
+//there is no limit.
+func WithBandwidthLimit(bytesPerSecond int) Option {
+	return func(c *config) {
+		if bytesPerSecond <= 0 {
+			c.errs = append(c.errs, fmt.Errorf("invalid bandwidth limit %d, must be positive", bytesPerSecond))
+			return
+		}
+		c.bandwidth = bytesPerSecond
+	}
+}
+
+//WithHooks has the client call hooks around each upload, e.g. to record
+//metrics or tracing spans. See Hooks.
+func WithHooks(hooks Hooks) Option {
+	return func(c *config) {
+		c.hooks = hooks
+	}
+}
@@ -0,0 +1,42 @@
+package gcs_test
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/jeromeku/go-gcs/gcs"
+	"google.golang.org/api/option"
+)
+
+func TestWithCompressedSuffix(t *testing.T) {
+	tests := []struct {
+		suffix string
+		valid  bool
+	}{
+		{"", true},
+		{".gz", true},
+		{".gzip", true},
+		{"gz", false},
+		{".", false},
+		{"/.gz", false},
+		{".gz/x", false},
+	}
+	sc, err := storage.NewClient(context.Background(), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+	for _, tt := range tests {
+		client, err := gcs.New(gcs.WithStorageClient(sc), gcs.WithCompressedSuffix(tt.suffix))
+		if err == nil {
+			client.Close()
+		}
+		if tt.valid && err != nil {
+			t.Errorf("New(WithCompressedSuffix(%q)) = %v, want nil", tt.suffix, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("New(WithCompressedSuffix(%q)) succeeded, want an error", tt.suffix)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gcs
+
+import (
+	"io"
+	"sync"
+)
+
+//progressReader reports the running total of bytes read from r to fn,
+//once per Read call, i.e. per chunk copied rather than per byte.
+type progressReader struct {
+	r     io.Reader
+	fn    func(bytesWritten int64)
+	total int64
+}
+
+//Read reads from the underlying reader and reports progress.
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.total += int64(n)
+		p.fn(p.total)
+	}
+	return n, err
+}
+
+//dirProgress aggregates the progress of the files of a directory upload
+//for DirOpts.Progress. A nil *dirProgress reports nothing.
+type dirProgress struct {
+	mu sync.Mutex
+	fn func(DirProgress)
+	//sizes are the sizes of the files when scanned, and counted the bytes
+	//of each file counted in state so far.
+	sizes   []int64
+	counted []int64
+	state   DirProgress
+}
+
+//newDirProgress returns the progress of uploading files reported to fn,
+//or nil if fn is nil.
+func newDirProgress(files []dirFile, fn func(DirProgress)) *dirProgress {
+	if fn == nil {
+		return nil
+	}
+	p := &dirProgress{
+		fn:      fn,
+		sizes:   make([]int64, len(files)),
+		counted: make([]int64, len(files)),
+	}
+	for i, f := range files {
+		p.sizes[i] = f.size
+		p.state.BytesTotal += f.size
+	}
+	p.state.FilesTotal = len(files)
+	return p
+}
+
+//file returns the UploadOpts.Progress of file i, which also calls
+//fileProgress if not nil.
+func (p *dirProgress) file(i int, fileProgress func(int64)) func(int64) {
+	if p == nil {
+		return fileProgress
+	}
+	return func(n int64) {
+		if fileProgress != nil {
+			fileProgress(n)
+		}
+		p.update(i, min(n, p.sizes[i]), false)
+	}
+}
+
+//done marks file i as finished.
+func (p *dirProgress) done(i int) {
+	if p != nil {
+		p.update(i, p.sizes[i], true)
+	}
+}
+
+//update counts n bytes of file i, finished or not, and reports the new
+//state. n is smaller than before when an upload is retried.
+func (p *dirProgress) update(i int, n int64, finished bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.BytesDone += n - p.counted[i]
+	p.counted[i] = n
+	if finished {
+		p.state.FilesDone++
+	}
+	p.fn(p.state)
+}
@@ -0,0 +1,451 @@
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	raw "google.golang.org/api/storage/v1"
+	htransport "google.golang.org/api/transport/http"
+)
+
+//defaultResumableChunkSize is the chunk size of resumable uploads that do
+//not set UploadOpts.ChunkSize, as for the storage writer.
+const defaultResumableChunkSize = 16 << 20
+
+//ResumeState is the state of a resumable upload, see UploadOpts.Resume.
+//It marshals to JSON, so that it can be persisted to continue an
+//interrupted upload later, even from another process.
+type ResumeState struct {
+	//SessionURI identifies the upload session on GCS. It is a bearer
+	//credential for the upload and should be stored as such. Sessions
+	//expire after a week, after which the upload starts over.
+	SessionURI string `json:"sessionURI"`
+
+	//Offset is the number of bytes of the object GCS has persisted.
+	Offset int64 `json:"offset"`
+}
+
+//resumableTransport starts and continues resumable upload sessions over
+//the JSON API, since the storage writer does not expose its sessions.
+type resumableTransport struct {
+	//ctx and opts create the HTTP client, when first needed.
+	ctx  context.Context
+	opts []option.ClientOption
+	//uploadURL is the base URL of uploads, ending in '/upload/storage/v1'.
+	uploadURL string
+	//userProject is billed for requests if not empty.
+	userProject string
+
+	once   sync.Once
+	client *http.Client
+	err    error
+}
+
+//newResumableTransport returns the transport of a client created with
+//clientOpts and cfg.
+func newResumableTransport(cfg config, clientOpts []option.ClientOption) *resumableTransport {
+	t := &resumableTransport{
+		ctx:         cfg.ctx,
+		opts:        clientOpts,
+		uploadURL:   "https://storage.googleapis.com/upload/storage/v1",
+		userProject: cfg.userProject,
+	}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		t.uploadURL = strings.TrimSuffix(host, "/") + "/upload/storage/v1"
+	} else if u, err := url.Parse(cfg.endpoint); err == nil && u.Host != "" {
+		t.uploadURL = u.Scheme + "://" + u.Host + "/upload/storage/v1"
+	}
+	return t
+}
+
+//httpClient returns the authenticated HTTP client of t.
+func (t *resumableTransport) httpClient() (*http.Client, error) {
+	t.once.Do(func() {
+		if os.Getenv("STORAGE_EMULATOR_HOST") != "" {
+			t.client = http.DefaultClient
+			return
+		}
+		opts := append([]option.ClientOption{option.WithScopes(storage.ScopeFullControl)}, t.opts...)
+		t.client, _, t.err = htransport.NewClient(t.ctx, opts...)
+		if t.err != nil {
+			t.err = fmt.Errorf("unable to create client for resumable uploads: %w", t.err)
+		}
+	})
+	return t.client, t.err
+}
+
+//newWriter returns a writer continuing the upload session of req.Resume
+//in bucket, or starting one if it has none.
+func (t *resumableTransport) newWriter(ctx context.Context, bucket string, req *WriteRequest) ObjectWriter {
+	w := &resumableWriter{ctx: ctx, t: t, bucket: bucket, req: req, chunkSize: req.ChunkSize}
+	if w.chunkSize <= 0 {
+		w.chunkSize = defaultResumableChunkSize
+	}
+	if t == nil {
+		w.err = errors.New("resumable uploads are not available on clients adopted with WithStorageClient")
+	}
+	return w
+}
+
+//resumableWriter writes an object through a resumable upload session.
+//It is given the whole stream of the object on every attempt: the bytes
+//GCS already persisted are discarded rather than sent again, which
+//relies on the stream, compressed or not, being the same every time.
+//The session is recorded in req.Resume as chunks are persisted.
+type resumableWriter struct {
+	ctx       context.Context
+	t         *resumableTransport
+	bucket    string
+	req       *WriteRequest
+	chunkSize int
+
+	started bool
+	//skip is the number of bytes of the stream still to discard.
+	skip int64
+	//buf holds the bytes of the stream from offset req.Resume.Offset not
+	//sent yet.
+	buf   []byte
+	attrs *storage.ObjectAttrs
+	//err is the first error, returned by every later call.
+	err error
+}
+
+//Write buffers b and sends every full chunk.
+func (w *resumableWriter) Write(b []byte) (int, error) {
+	if err := w.start(); err != nil {
+		return 0, err
+	}
+	n := len(b)
+	skip := min(w.skip, int64(len(b)))
+	w.skip -= skip
+	if w.attrs != nil {
+		//The object is already complete; the rest of the stream is moot.
+		return n, nil
+	}
+	w.buf = append(w.buf, b[skip:]...)
+	//A chunk is only sent once a byte past it is buffered, since the last
+	//chunk must be sent as such.
+	for len(w.buf) > w.chunkSize {
+		if err := w.send(w.chunkSize, false); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+//Close sends the last chunk, finalizing the object.
+func (w *resumableWriter) Close() error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	if err := w.ctx.Err(); err != nil {
+		//The write was abandoned; the session is kept for a later attempt.
+		return err
+	}
+	if w.attrs != nil {
+		return nil
+	}
+	if w.skip > 0 {
+		w.err = fmt.Errorf("%w: the data is shorter than the %d bytes already uploaded; the file changed since the upload started, "+
+			"start over without the ResumeState", ErrIntegrity, w.req.Resume.Offset)
+		return w.err
+	}
+	//GCS may persist part of the last chunk only; the rest is sent again
+	//for as long as each request makes progress.
+	for w.attrs == nil {
+		if err := w.send(len(w.buf), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Attrs returns the attributes of the object once Close succeeded.
+func (w *resumableWriter) Attrs() *storage.ObjectAttrs {
+	return w.attrs
+}
+
+//start queries the persisted offset of the session, or starts one if
+//there is none or it expired, when first called.
+func (w *resumableWriter) start() error {
+	if w.err != nil || w.started {
+		return w.err
+	}
+	w.started = true
+	state := w.req.Resume
+
+	if state.SessionURI != "" {
+		resp, err := w.do(http.MethodPut, state.SessionURI, nil, "bytes */*", false)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		err = w.handleResponse(resp)
+		//Sessions that expired or were cancelled are started over.
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && (gerr.Code == http.StatusNotFound || gerr.Code == http.StatusGone) {
+			state.SessionURI = ""
+		} else if err != nil {
+			w.err = err
+			return err
+		}
+		if w.attrs != nil {
+			//The upload completed before, e.g. just before an interruption.
+			return w.checkpoint()
+		}
+	}
+	if state.SessionURI == "" {
+		state.Offset = 0
+		if err := w.newSession(); err != nil {
+			w.err = err
+			return err
+		}
+	}
+	w.skip = state.Offset
+	return w.checkpoint()
+}
+
+//newSession starts an upload session for w.req and records it.
+func (w *resumableWriter) newSession() error {
+	client, err := w.t.httpClient()
+	if err != nil {
+		return err
+	}
+
+	attrs := w.req.Attrs
+	obj := &raw.Object{
+		Name:               attrs.Name,
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		CacheControl:       attrs.CacheControl,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentLanguage:    attrs.ContentLanguage,
+		StorageClass:       attrs.StorageClass,
+		KmsKeyName:         attrs.KMSKeyName,
+		TemporaryHold:      attrs.TemporaryHold,
+		EventBasedHold:     attrs.EventBasedHold,
+		Metadata:           attrs.Metadata,
+	}
+	if !attrs.CustomTime.IsZero() {
+		obj.CustomTime = attrs.CustomTime.Format(time.RFC3339Nano)
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"uploadType": {"resumable"}, "name": {attrs.Name}}
+	if conds := w.req.Conditions; conds != nil {
+		query.Set("ifGenerationMatch", strconv.FormatInt(conds.GenerationMatch, 10))
+	}
+	if attrs.PredefinedACL != "" {
+		query.Set("predefinedAcl", attrs.PredefinedACL)
+	}
+	if w.t.userProject != "" {
+		query.Set("userProject", w.t.userProject)
+	}
+	u := w.t.uploadURL + "/b/" + url.PathEscape(w.bucket) + "/o?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	if attrs.ContentType != "" {
+		req.Header.Set("X-Upload-Content-Type", attrs.ContentType)
+	}
+	w.setKeyHeaders(req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return errors.New("GCS started a resumable upload without a session URI")
+	}
+	w.req.Resume.SessionURI = location
+	return nil
+}
+
+//send sends the first n bytes of the buffer, as the last chunk if final,
+//and records the offset GCS persisted.
+func (w *resumableWriter) send(n int, final bool) error {
+	offset := w.req.Resume.Offset
+	var contentRange string
+	switch {
+	case n > 0 && final:
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, offset+int64(n))
+	case n > 0:
+		contentRange = fmt.Sprintf("bytes %d-%d/*", offset, offset+int64(n)-1)
+	default:
+		contentRange = fmt.Sprintf("bytes */%d", offset)
+	}
+
+	resp, err := w.do(http.MethodPut, w.req.Resume.SessionURI, w.buf[:n], contentRange, final)
+	if err == nil {
+		err = w.handleResponse(resp)
+	}
+	if err != nil {
+		w.err = err
+		return err
+	}
+	if w.attrs != nil {
+		w.buf = nil
+		return w.checkpoint()
+	}
+
+	//GCS may persist less than was sent; the rest is sent again. A request
+	//persisting nothing fails, as a transient error, rather than being
+	//repeated forever; a retry continues the session.
+	persisted := w.req.Resume.Offset - offset
+	switch {
+	case persisted == 0:
+		w.err = fmt.Errorf("%w: GCS persisted none of the %d bytes sent at offset %d of the resumable upload",
+			io.ErrUnexpectedEOF, n, offset)
+		return w.err
+	case persisted < 0 || persisted > int64(n):
+		w.err = fmt.Errorf("invalid offset %d persisted by GCS after sending %d bytes at offset %d of the resumable upload",
+			w.req.Resume.Offset, n, offset)
+		return w.err
+	}
+	w.buf = w.buf[persisted:]
+	return w.checkpoint()
+}
+
+//do sends a request with body to the session URI u.
+func (w *resumableWriter) do(method string, u string, body []byte, contentRange string, final bool) (*http.Response, error) {
+	client, err := w.t.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(w.ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Range", contentRange)
+	if final && w.req.SendCRC32C {
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], w.req.Attrs.CRC32C)
+		req.Header.Set("X-Goog-Hash", "crc32c="+base64.StdEncoding.EncodeToString(crc[:]))
+	}
+	w.setKeyHeaders(req.Header)
+	return client.Do(req)
+}
+
+//handleResponse records the outcome of a request to the session: the
+//persisted offset of an incomplete upload, or the attributes of the
+//object once complete. Other responses are returned as errors.
+func (w *resumableWriter) handleResponse(resp *http.Response) error {
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPermanentRedirect:
+		//Range is 'bytes=0-N' for the persisted bytes, or absent if none.
+		w.req.Resume.Offset = 0
+		if r := resp.Header.Get("Range"); r != "" {
+			_, last, ok := strings.Cut(r, "-")
+			end, err := strconv.ParseInt(last, 10, 64)
+			if !ok || err != nil {
+				return fmt.Errorf("invalid Range %q in resumable upload response", r)
+			}
+			w.req.Resume.Offset = end + 1
+		}
+		return nil
+	case http.StatusOK, http.StatusCreated:
+		var obj raw.Object
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			return fmt.Errorf("invalid object in resumable upload response: %w", err)
+		}
+		attrs, err := objectAttrs(&obj)
+		if err != nil {
+			return err
+		}
+		w.attrs = attrs
+		//The session is finished, so that the state can start the next
+		//upload rather than query this one again.
+		*w.req.Resume = ResumeState{}
+		return nil
+	}
+	return googleapi.CheckResponse(resp)
+}
+
+//checkpoint passes the state of the session to req.Checkpoint, if set.
+func (w *resumableWriter) checkpoint() error {
+	if w.req.Checkpoint == nil {
+		return nil
+	}
+	if err := w.req.Checkpoint(*w.req.Resume); err != nil {
+		w.err = fmt.Errorf("checkpoint of resumable upload failed: %w", err)
+		return w.err
+	}
+	return nil
+}
+
+//setKeyHeaders sets the headers of the customer-supplied key of the
+//object, if any, which every request of the session must carry.
+func (w *resumableWriter) setKeyHeaders(h http.Header) {
+	key := w.req.EncryptionKey
+	if len(key) == 0 {
+		return
+	}
+	sum := sha256.Sum256(key)
+	h.Set("X-Goog-Encryption-Algorithm", "AES256")
+	h.Set("X-Goog-Encryption-Key", base64.StdEncoding.EncodeToString(key))
+	h.Set("X-Goog-Encryption-Key-Sha256", base64.StdEncoding.EncodeToString(sum[:]))
+}
+
+//objectAttrs converts the object resource returned by the JSON API to
+//the attributes used by the upload path.
+func objectAttrs(obj *raw.Object) (*storage.ObjectAttrs, error) {
+	crc, err := base64.StdEncoding.DecodeString(obj.Crc32c)
+	if err != nil || len(crc) != 4 {
+		return nil, fmt.Errorf("invalid crc32c %q in resumable upload response", obj.Crc32c)
+	}
+	attrs := &storage.ObjectAttrs{
+		Name:               obj.Name,
+		Bucket:             obj.Bucket,
+		Size:               int64(obj.Size),
+		Generation:         obj.Generation,
+		Metageneration:     obj.Metageneration,
+		CRC32C:             binary.BigEndian.Uint32(crc),
+		ContentType:        obj.ContentType,
+		ContentEncoding:    obj.ContentEncoding,
+		CacheControl:       obj.CacheControl,
+		ContentDisposition: obj.ContentDisposition,
+		ContentLanguage:    obj.ContentLanguage,
+		StorageClass:       obj.StorageClass,
+		KMSKeyName:         obj.KmsKeyName,
+		TemporaryHold:      obj.TemporaryHold,
+		EventBasedHold:     obj.EventBasedHold,
+		Metadata:           obj.Metadata,
+	}
+	attrs.Created, _ = time.Parse(time.RFC3339, obj.TimeCreated)
+	attrs.Updated, _ = time.Parse(time.RFC3339, obj.Updated)
+	if obj.CustomTime != "" {
+		attrs.CustomTime, _ = time.Parse(time.RFC3339, obj.CustomTime)
+	}
+	return attrs, nil
+}
@@ -0,0 +1,447 @@
+package gcs_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	raw "google.golang.org/api/storage/v1"
+)
+
+//chunkSize is the chunk size of the resumable uploads of the tests.
+const chunkSize = 256 << 10
+
+//resumableServer serves the resumable upload protocol of the JSON API
+//for one bucket, along with the requests around it: tokens, bucket
+//metadata and object deletes.
+type resumableServer struct {
+	*httptest.Server
+
+	//persist returns how many of the n bytes of a chunk that is not the
+	//last are persisted. By default all are.
+	persist func(n int) int
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	objects  map[string]*raw.Object
+	data     map[string][]byte
+	//received counts the bytes of object data received.
+	received int
+	//corrupt makes the CRC32C of completed objects wrong.
+	corrupt bool
+}
+
+//session is an upload session of a resumableServer.
+type session struct {
+	obj  raw.Object
+	data []byte
+	gone bool
+	done *raw.Object
+}
+
+//newResumableServer returns a started resumableServer.
+func newResumableServer(t *testing.T) *resumableServer {
+	s := &resumableServer{
+		sessions: map[string]*session{},
+		objects:  map[string]*raw.Object{},
+		data:     map[string][]byte{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, map[string]any{"access_token": "token", "token_type": "Bearer", "expires_in": 3600})
+	})
+	mux.HandleFunc("GET /storage/v1/b/bucket", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, &raw.Bucket{Kind: "storage#bucket", Name: "bucket"})
+	})
+	mux.HandleFunc("DELETE /storage/v1/b/bucket/o/{object...}", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.objects, r.PathValue("object"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /upload/storage/v1/b/bucket/o", s.start)
+	mux.HandleFunc("PUT /session/{id}", s.put)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+//start starts an upload session.
+func (s *resumableServer) start(w http.ResponseWriter, r *http.Request) {
+	var obj raw.Object
+	if r.URL.Query().Get("uploadType") != "resumable" || json.NewDecoder(r.Body).Decode(&obj) != nil {
+		http.Error(w, "invalid resumable upload", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strconv.Itoa(len(s.sessions))
+	s.sessions[id] = &session{obj: obj}
+	w.Header().Set("Location", s.URL+"/session/"+id)
+}
+
+//put serves a request of a session: a status query, a chunk or the last
+//chunk, as told by the Content-Range header.
+func (s *resumableServer) put(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[r.PathValue("id")]
+	switch {
+	case !ok:
+		http.Error(w, "no such session", http.StatusNotFound)
+		return
+	case sess.gone:
+		http.Error(w, "session expired", http.StatusGone)
+		return
+	case sess.done != nil:
+		writeTestJSON(w, sess.done)
+		return
+	}
+
+	spec, total, _ := strings.Cut(strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes "), "/")
+	if spec != "*" {
+		first, _, _ := strings.Cut(spec, "-")
+		if first != strconv.Itoa(len(sess.data)) {
+			http.Error(w, "chunk not at the persisted offset", http.StatusBadRequest)
+			return
+		}
+		s.received += len(body)
+		n := len(body)
+		if total == "*" && s.persist != nil {
+			n = s.persist(n)
+		}
+		sess.data = append(sess.data, body[:n]...)
+	}
+	if total != "*" && total == strconv.Itoa(len(sess.data)) {
+		sess.done = s.commit(sess)
+		writeTestJSON(w, sess.done)
+		return
+	}
+	if len(sess.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(sess.data)-1))
+	}
+	w.WriteHeader(http.StatusPermanentRedirect)
+}
+
+//commit stores the object of sess.
+func (s *resumableServer) commit(sess *session) *raw.Object {
+	crc := crc32.Checksum(sess.data, crc32.MakeTable(crc32.Castagnoli))
+	if s.corrupt {
+		crc++
+	}
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc)
+	obj := sess.obj
+	obj.Kind = "storage#object"
+	obj.Bucket = "bucket"
+	obj.Size = uint64(len(sess.data))
+	obj.Generation = int64(len(s.objects) + 1)
+	obj.Metageneration = 1
+	obj.Crc32c = base64.StdEncoding.EncodeToString(sum[:])
+	obj.TimeCreated = time.Now().Format(time.RFC3339)
+	obj.Updated = obj.TimeCreated
+	s.objects[obj.Name] = &obj
+	s.data[obj.Name] = bytes.Clone(sess.data)
+	return &obj
+}
+
+//object returns the data of the object name, if it exists.
+func (s *resumableServer) object(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[name]; !ok {
+		return nil, false
+	}
+	return s.data[name], true
+}
+
+//addSession adds sess as the session id, e.g. one started by an earlier
+//upload.
+func (s *resumableServer) addSession(id string, sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = sess
+}
+
+//bytesReceived returns the number of bytes of object data received.
+func (s *resumableServer) bytesReceived() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+//writeTestJSON writes v as a JSON response.
+func writeTestJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+//newResumableClient returns a client talking to s through WithEndpoint,
+//authenticated by a service account key whose tokens s issues.
+func newResumableClient(t *testing.T, s *resumableServer) *gcs.Client {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: must(x509.MarshalPKCS8PrivateKey(key))})
+	credJSON, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"project_id":     "test-project",
+		"private_key_id": "key",
+		"private_key":    string(keyPEM),
+		"client_email":   "uploader@test-project.iam.gserviceaccount.com",
+		"token_uri":      s.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := gcs.New(
+		gcs.WithCredentialsJSON(credJSON),
+		gcs.WithEndpoint(s.URL+"/storage/v1/"),
+		gcs.WithProjectID("test-project"),
+		gcs.WithLogger(slog.New(slog.DiscardHandler)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+//must returns v, panicking on err.
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+//resumableData returns n bytes of incompressible data.
+func resumableData(n int) []byte {
+	data := make([]byte, n)
+	rand.Read(data)
+	return data
+}
+
+//uploadResumable uploads data as 'data.bin' with state and the options
+//of the tests, recording the checkpoints.
+func uploadResumable(client *gcs.Client, file string, state *gcs.ResumeState, checkpoints *[]gcs.ResumeState) (*gcs.UploadResult, error) {
+	opts := gcs.UploadOpts{
+		ObjectName: "data.bin",
+		ChunkSize:  chunkSize,
+		Resume:     state,
+		Checkpoint: func(state gcs.ResumeState) error {
+			*checkpoints = append(*checkpoints, state)
+			return nil
+		},
+	}
+	return client.UploadWithOptions(context.Background(), "bucket", file, opts)
+}
+
+func TestResumableUpload(t *testing.T) {
+	s := newResumableServer(t)
+	client := newResumableClient(t, s)
+	data := resumableData(2*chunkSize + 1000)
+
+	var state gcs.ResumeState
+	var checkpoints []gcs.ResumeState
+	result, err := uploadResumable(client, writeFile(t, "data.bin", data), &state, &checkpoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, ok := s.object("data.bin")
+	if !ok || !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+	if result.Size != int64(len(data)) || result.CRC32C != crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)) {
+		t.Errorf("result size %d crc32c %08x, want the attributes of the data", result.Size, result.CRC32C)
+	}
+	if s.bytesReceived() != len(data) {
+		t.Errorf("received %d bytes, want %d", s.bytesReceived(), len(data))
+	}
+	//Started, two chunks, then completed.
+	var offsets []int64
+	for _, c := range checkpoints {
+		offsets = append(offsets, c.Offset)
+	}
+	if want := []int64{0, chunkSize, 2 * chunkSize, 0}; fmt.Sprint(offsets) != fmt.Sprint(want) {
+		t.Errorf("checkpoint offsets = %v, want %v", offsets, want)
+	}
+	if last := checkpoints[len(checkpoints)-1]; last != (gcs.ResumeState{}) {
+		t.Errorf("last checkpoint = %+v, want the zero state", last)
+	}
+	if state != (gcs.ResumeState{}) {
+		t.Errorf("state = %+v after the upload, want the zero state", state)
+	}
+}
+
+func TestResumableUploadResumesSession(t *testing.T) {
+	s := newResumableServer(t)
+	client := newResumableClient(t, s)
+	data := resumableData(2*chunkSize + 1000)
+	file := writeFile(t, "data.bin", data)
+
+	//An earlier upload persisted the first chunk of the session. The
+	//offset the server reports wins over the stale one of the state.
+	s.addSession("0", &session{obj: raw.Object{Name: "data.bin"}, data: bytes.Clone(data[:chunkSize])})
+	state := gcs.ResumeState{SessionURI: s.URL + "/session/0", Offset: 1}
+	var checkpoints []gcs.ResumeState
+	if _, err := uploadResumable(client, file, &state, &checkpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored, _ := s.object("data.bin"); !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+	if want := len(data) - chunkSize; s.bytesReceived() != want {
+		t.Errorf("received %d bytes, want the %d not persisted", s.bytesReceived(), want)
+	}
+	if first := checkpoints[0]; first.SessionURI != s.URL+"/session/0" || first.Offset != chunkSize {
+		t.Errorf("first checkpoint = %+v, want the session at offset %d", first, chunkSize)
+	}
+}
+
+func TestResumableUploadRestartsExpiredSession(t *testing.T) {
+	s := newResumableServer(t)
+	client := newResumableClient(t, s)
+	data := resumableData(chunkSize + 1000)
+
+	s.addSession("0", &session{obj: raw.Object{Name: "data.bin"}, data: bytes.Clone(data[:chunkSize]), gone: true})
+	state := gcs.ResumeState{SessionURI: s.URL + "/session/0", Offset: chunkSize}
+	var checkpoints []gcs.ResumeState
+	if _, err := uploadResumable(client, writeFile(t, "data.bin", data), &state, &checkpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored, _ := s.object("data.bin"); !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+	if s.bytesReceived() != len(data) {
+		t.Errorf("received %d bytes, want all %d in the new session", s.bytesReceived(), len(data))
+	}
+	if first := checkpoints[0]; first.SessionURI != s.URL+"/session/1" || first.Offset != 0 {
+		t.Errorf("first checkpoint = %+v, want a new session at offset 0", first)
+	}
+}
+
+func TestResumableUploadPartialChunks(t *testing.T) {
+	s := newResumableServer(t)
+	//Only the first half of each chunk is persisted, and the rest is sent
+	//again with the next.
+	s.persist = func(n int) int { return n / 2 }
+	client := newResumableClient(t, s)
+	data := resumableData(2*chunkSize + 1000)
+
+	var state gcs.ResumeState
+	var checkpoints []gcs.ResumeState
+	if _, err := uploadResumable(client, writeFile(t, "data.bin", data), &state, &checkpoints); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := s.object("data.bin"); !bytes.Equal(stored, data) {
+		t.Fatalf("stored %d bytes, want %d", len(stored), len(data))
+	}
+	if s.bytesReceived() <= len(data) {
+		t.Errorf("received %d bytes, want more than %d since parts were sent again", s.bytesReceived(), len(data))
+	}
+}
+
+func TestResumableUploadFailsWithoutProgress(t *testing.T) {
+	s := newResumableServer(t)
+	s.persist = func(n int) int { return 0 }
+	client := newResumableClient(t, s)
+	data := resumableData(2*chunkSize + 1000)
+
+	file := writeFile(t, "data.bin", data)
+	done := make(chan error, 1)
+	go func() {
+		var state gcs.ResumeState
+		var checkpoints []gcs.ResumeState
+		_, err := uploadResumable(client, file, &state, &checkpoints)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("upload = %v, want io.ErrUnexpectedEOF", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("upload did not return while GCS persisted nothing")
+	}
+	if _, ok := s.object("data.bin"); ok {
+		t.Error("object committed")
+	}
+}
+
+func TestResumableUploadCheckpointError(t *testing.T) {
+	s := newResumableServer(t)
+	client := newResumableClient(t, s)
+	data := resumableData(2*chunkSize + 1000)
+
+	errSave := errors.New("disk full")
+	var state gcs.ResumeState
+	calls := 0
+	opts := gcs.UploadOpts{
+		ObjectName: "data.bin",
+		ChunkSize:  chunkSize,
+		Resume:     &state,
+		Checkpoint: func(gcs.ResumeState) error {
+			calls++
+			if calls == 2 {
+				return errSave
+			}
+			return nil
+		},
+	}
+	_, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, "data.bin", data), opts)
+	if !errors.Is(err, errSave) {
+		t.Fatalf("upload = %v, want the checkpoint error", err)
+	}
+	if calls != 2 {
+		t.Errorf("%d checkpoints, want the upload to stop at the failing one", calls)
+	}
+	if _, ok := s.object("data.bin"); ok {
+		t.Error("object committed")
+	}
+	if state.SessionURI == "" || state.Offset != chunkSize {
+		t.Errorf("state = %+v, want the session kept at offset %d", state, chunkSize)
+	}
+}
+
+func TestResumableUploadChecksumMismatch(t *testing.T) {
+	s := newResumableServer(t)
+	s.corrupt = true
+	client := newResumableClient(t, s)
+	data := resumableData(chunkSize + 1000)
+
+	var state gcs.ResumeState
+	var checkpoints []gcs.ResumeState
+	_, err := uploadResumable(client, writeFile(t, "data.bin", data), &state, &checkpoints)
+	if !errors.Is(err, gcs.ErrIntegrity) {
+		t.Fatalf("upload = %v, want ErrIntegrity", err)
+	}
+	if _, ok := s.object("data.bin"); ok {
+		t.Error("corrupted object kept")
+	}
+}
@@ -0,0 +1,99 @@
+package gcs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+//SetRetention prevents objectName in a GCS bucket from being deleted or
+//replaced until until
+// - The bucket must have object retention enabled, which can only be done
+//when creating it.
+// - The retention is unlocked: it can be extended later, but shortening
+//or removing it requires overriding it with the raw client.
+func (c *Client) SetRetention(bucket string, objectName string, until time.Time) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	if !until.After(time.Now()) {
+		return fmt.Errorf("invalid retention time %s, must be in the future", until)
+	}
+	if c.skipDryRun("setting retention", "bucket", bucket, "object", objectName, "until", until) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	_, err := c.storageBucket(bucket).Object(objectName).Update(ctx, storage.ObjectAttrsToUpdate{
+		Retention: &storage.ObjectRetention{Mode: "Unlocked", RetainUntil: until},
+	})
+	if err != nil {
+		c.log.Error("GCS: Error setting object retention", "object", objectName, "err", err)
+		if isRetentionRejection(err) {
+			return fmt.Errorf("unable to set retention on gs://%s/%s, the bucket does not allow it "+
+				"(object retention disabled, or a shorter retention is already locked): %w", bucket, objectName, err)
+		}
+		return gcsError(bucket, objectName, err)
+	}
+	return nil
+}
+
+//SetBucketRetention sets the retention policy of a GCS bucket, so that
+//no object in it can be deleted or replaced until it is period old
+// - Event-based holds delay the start of the period; see
+//UploadOpts.EventBasedHold.
+// - It fails if the policy of the bucket is locked and period is shorter.
+func (c *Client) SetBucketRetention(bucket string, period time.Duration) error {
+	if err := c.checkOpen(); err != nil {
+		return err
+	}
+	if period <= 0 {
+		return fmt.Errorf("invalid retention period %s, must be positive", period)
+	}
+	if c.skipDryRun("setting bucket retention", "bucket", bucket, "period", period) {
+		return nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	_, err := c.storageBucket(bucket).Update(ctx, storage.BucketAttrsToUpdate{
+		RetentionPolicy: &storage.RetentionPolicy{RetentionPeriod: period},
+	})
+	if err != nil {
+		c.log.Error("GCS: Error setting bucket retention", "bucket", bucket, "err", err)
+		if isRetentionRejection(err) {
+			return fmt.Errorf("unable to set retention on gs://%s, its retention policy is locked: %w", bucket, err)
+		}
+		return gcsError(bucket, "", err)
+	}
+	return nil
+}
+
+//retentionReasons are the reasons of the API errors GCS returns when
+//refusing a change because of the retention configuration of a bucket or
+//of the holds of an object.
+var retentionReasons = map[string]bool{
+	"retentionPolicyNotMet":     true,
+	"objectUnderActiveHold":     true,
+	"objectRetentionNotEnabled": true,
+}
+
+//isRetentionRejection reports whether err is GCS refusing a retention
+//change because of the configuration of the bucket. Other failures with
+//the same status, such as failed preconditions, are not.
+func isRetentionRejection(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return false
+	}
+	for _, item := range gerr.Errors {
+		if retentionReasons[item.Reason] {
+			return true
+		}
+	}
+	return false
+}
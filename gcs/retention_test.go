@@ -0,0 +1,33 @@
+package gcs
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsRetentionRejection(t *testing.T) {
+	apiError := func(code int, reason string) error {
+		return &googleapi.Error{Code: code, Errors: []googleapi.ErrorItem{{Reason: reason}}}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"retention policy", apiError(http.StatusForbidden, "retentionPolicyNotMet"), true},
+		{"object hold", apiError(http.StatusForbidden, "objectUnderActiveHold"), true},
+		{"wrapped", fmt.Errorf("update: %w", apiError(http.StatusBadRequest, "retentionPolicyNotMet")), true},
+		{"precondition", apiError(http.StatusPreconditionFailed, "conditionNotMet"), false},
+		{"bad request", apiError(http.StatusBadRequest, "invalid"), false},
+		{"no reason", &googleapi.Error{Code: http.StatusPreconditionFailed}, false},
+		{"not an API error", fmt.Errorf("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetentionRejection(tt.err); got != tt.want {
+			t.Errorf("%s: isRetentionRejection(%v) = %t, want %t", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+//defaultBaseDelay is used when RetryPolicy.BaseDelay is not set.
+const defaultBaseDelay = 100 * time.Millisecond
+
+//maxRetryDelay caps the delay between two attempts.
+const maxRetryDelay = 30 * time.Second
+
+//RetryPolicy controls how operations are retried on transient errors such
+//as 429 and 5xx responses or reset connections. Other errors, e.g. 403,
+//404 or invalid arguments, fail on the first attempt.
+//The zero value makes a single attempt.
+type RetryPolicy struct {
+	//MaxAttempts is the total number of attempts, including the first.
+	MaxAttempts int
+
+	//BaseDelay is the delay before the first retry. It doubles with each
+	//further retry, up to 30s, and is jittered. Defaults to 100ms.
+	BaseDelay time.Duration
+
+	//Deadline bounds the wall-clock time of all attempts and the delays
+	//between them, so a flaky object cannot retry for longer. The attempt
+	//in flight when it passes is aborted, and the last error is returned
+	//wrapped with a message saying the budget was exceeded. Zero uses the
+	//client deadline set by WithRetryDeadline, if any.
+	Deadline time.Duration
+}
+
+//do runs op until it succeeds, fails with a non-retryable error, the
+//attempts are exhausted or ctx is done, and returns the last error.
+//op runs under ctx bounded by p.Deadline. Retries are logged to log.
+func (p RetryPolicy) do(ctx context.Context, log *slog.Logger, op func(ctx context.Context) error) error {
+	delay := p.BaseDelay
+	if delay <= 0 {
+		delay = defaultBaseDelay
+	}
+	parent := ctx
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+	//exceeded wraps err if the deadline of p, rather than the parent
+	//context, ended the attempts.
+	exceeded := func(err error) error {
+		if err != nil && p.Deadline > 0 && ctx.Err() != nil && parent.Err() == nil {
+			return fmt.Errorf("retry budget of %s exceeded: %w", p.Deadline, err)
+		}
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op(ctx)
+		if err == nil || attempt >= p.MaxAttempts || !storage.ShouldRetry(err) {
+			return exceeded(err)
+		}
+
+		//Jitter the delay over [delay/2, delay] so that concurrent callers
+		//do not retry in lockstep.
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		log.Warn("GCS: Retrying after error", "wait", wait, "err", err)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return exceeded(err)
+		case <-t.C:
+		}
+
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
@@ -0,0 +1,47 @@
+//Package secretcreds loads the credentials of a gcs.Client from Google
+//Secret Manager, so that a service account key never has to be written
+//to disk. It is a separate package so that programs not using it do not
+//depend on the Secret Manager client.
+package secretcreds
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"github.com/jeromeku/go-gcs/gcs"
+	"google.golang.org/api/option"
+)
+
+//WithSecretCredentials fetches the credentials JSON, such as a service
+//account key, stored in the Secret Manager secret version name, e.g.
+//'projects/p/secrets/gcs-key/versions/latest', and returns the
+//gcs.Option authenticating a client with it:
+//
+//	opt, err := secretcreds.WithSecretCredentials(ctx, name)
+//	if err != nil {
+//		return err
+//	}
+//	client, err := gcs.New(opt)
+//
+//Secret Manager is accessed with Application Default Credentials, or
+//with opts.
+func WithSecretCredentials(ctx context.Context, name string, opts ...option.ClientOption) (gcs.Option, error) {
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") || !strings.Contains(name, "/versions/") {
+		return nil, fmt.Errorf("invalid secret version %q, want projects/P/secrets/S/versions/V", name)
+	}
+
+	client, err := secretmanager.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Secret Manager client: %w", err)
+	}
+	defer client.Close()
+
+	resp, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials from secret %s: %w", name, err)
+	}
+	return gcs.WithCredentialsJSON(resp.GetPayload().GetData()), nil
+}
@@ -0,0 +1,81 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+//ListSoftDeleted returns the soft-deleted objects of a GCS bucket whose
+//names start with prefix, i.e. objects deleted within the soft delete
+//retention window of the bucket
+// - Generation identifies each object for Restore; an object deleted
+//several times is listed once per generation.
+// - Fails with a descriptive error if the bucket has soft delete disabled.
+func (c *Client) ListSoftDeleted(bucket string, prefix string) ([]*ObjectInfo, error) {
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+
+	var deleted []*ObjectInfo
+	it := c.storageBucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix, SoftDeleted: true})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return deleted, nil
+		}
+		if err != nil {
+			c.log.Error("GCS: Error listing soft-deleted objects", "bucket", bucket, "err", err)
+			return nil, c.softDeleteError(ctx, bucket, "", err)
+		}
+		deleted = append(deleted, newObjectInfo(attrs))
+	}
+}
+
+//Restore makes the soft-deleted generation of objectName in a GCS bucket
+//live again and returns the generation of the restored object, which
+//differs from the deleted one
+// - generation is one listed by ListSoftDeleted.
+// - A live object of the same name is replaced, or kept as noncurrent in
+//a bucket with versioning.
+// - Fails with a descriptive error if the bucket has soft delete disabled,
+//and with an error wrapping ErrObjectNotFound if the generation is not
+//soft-deleted, e.g. because its retention window has passed.
+func (c *Client) Restore(bucket string, objectName string, generation int64) (int64, error) {
+	if err := c.checkOpen(); err != nil {
+		return 0, err
+	}
+	if generation <= 0 {
+		return 0, fmt.Errorf("invalid generation %d", generation)
+	}
+	if c.skipDryRun("restoring object", "bucket", bucket, "object", objectName, "generation", generation) {
+		return 0, nil
+	}
+	ctx, cancel := c.withTimeout(c.ctx)
+	defer cancel()
+	if err := c.wait(ctx); err != nil {
+		return 0, err
+	}
+
+	attrs, err := c.storageBucket(bucket).Object(objectName).Generation(generation).Restore(ctx, &storage.RestoreOptions{})
+	if err != nil {
+		c.log.Error("GCS: Error restoring object", "object", objectName, "generation", generation, "err", err)
+		return 0, c.softDeleteError(ctx, bucket, objectName, err)
+	}
+	return attrs.Generation, nil
+}
+
+//softDeleteError returns err, returned by a soft delete operation on
+//bucket, as gcsError does, unless the bucket has soft delete disabled,
+//in which case the error says so.
+func (c *Client) softDeleteError(ctx context.Context, bucket string, objectName string, err error) error {
+	attrs, aerr := c.storageBucket(bucket).Attrs(ctx)
+	if aerr == nil && (attrs.SoftDeletePolicy == nil || attrs.SoftDeletePolicy.RetentionDuration == 0) {
+		return fmt.Errorf("gs://%s has soft delete disabled, so deleted objects cannot be listed or restored: %w", bucket, err)
+	}
+	return gcsError(bucket, objectName, err)
+}
@@ -0,0 +1,60 @@
+package gcs
+
+import (
+	"context"
+	"fmt"
+)
+
+//SyncOpts configures a Sync.
+type SyncOpts struct {
+	//DirOpts apply to the upload of the local directory. SkipIfUnchanged
+	//is always set.
+	DirOpts
+
+	//Delete removes objects under the prefix that have no local file
+	//anymore. Deletion is skipped if any file failed to upload, so that an
+	//object is never deleted because its file could not be read.
+	Delete bool
+}
+
+//Sync mirrors localDir to prefix in GCS bucket, in the manner of rsync
+// - Files are uploaded as by UploadDir, except that files whose object
+//already has the same content, i.e. the same CRC32C, and the same content
+//type and metadata are skipped. Empty files are compared like any other.
+// - Objects without a local file are kept; see SyncWithOptions to delete
+//them.
+func (c *Client) Sync(bucket string, localDir string, prefix string) error {
+	return c.SyncWithOptions(c.ctx, bucket, localDir, prefix, SyncOpts{DirOpts: DirOpts{UploadOpts: defaultUploadOpts}})
+}
+
+//SyncWithOptions is like Sync but runs under ctx and is configured by
+//opts.
+func (c *Client) SyncWithOptions(ctx context.Context, bucket string, localDir string, prefix string, opts SyncOpts) error {
+	opts.SkipIfUnchanged = true
+
+	local := map[string]bool{}
+	err := c.uploadDir(ctx, bucket, localDir, prefix, opts.DirOpts, func(result *UploadResult) {
+		local[result.Name] = true
+	})
+	if err != nil || !opts.Delete {
+		return err
+	}
+
+	var stale []string
+	err = c.listFunc(ctx, bucket, withPrefix(prefix, ""), func(objectName string) error {
+		if !local[objectName] {
+			stale = append(stale, objectName)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, objectName := range stale {
+		c.log.Info("GCS: Deleting object without local file", "object", objectName)
+		if err := c.deleteObject(ctx, bucket, objectName); err != nil {
+			return fmt.Errorf("%s: %w", objectName, err)
+		}
+	}
+	return nil
+}
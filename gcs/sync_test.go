@@ -0,0 +1,81 @@
+package gcs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+)
+
+//newSyncTree returns a directory of two files, and a server whose bucket
+//has an object under 'backup' for one of them, two stale objects and one
+//outside the prefix.
+func newSyncTree(t *testing.T) (string, *gcsfake.Server) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	seed := newTestClient(t, server)
+	for _, name := range []string{"backup/a.txt", "backup/stale-1.txt", "backup/stale-2.txt", "other/c.txt"} {
+		opts := gcs.UploadOpts{ObjectName: name}
+		if _, err := seed.UploadWithOptions(context.Background(), "bucket", writeFile(t, "seed.txt", []byte(name)), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, server
+}
+
+func TestSyncDeletesStaleObjects(t *testing.T) {
+	dir, server := newSyncTree(t)
+	client := newTestClient(t, server)
+
+	opts := gcs.SyncOpts{Delete: true}
+	if err := client.SyncWithOptions(context.Background(), "bucket", dir, "backup", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"backup/a.txt", "backup/b.txt", "other/c.txt"}
+	if names := server.ObjectNames("bucket"); !slices.Equal(names, want) {
+		t.Errorf("objects = %q, want %q", names, want)
+	}
+	if obj, _ := server.Object("bucket", "backup/a.txt"); string(obj.Data) != "a.txt" {
+		t.Errorf("backup/a.txt = %q, want the local file", obj.Data)
+	}
+}
+
+func TestSyncCancelStopsDeletes(t *testing.T) {
+	dir, server := newSyncTree(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//Sync is cancelled once the first stale object is deleted.
+	handler := server.Handler()
+	var once sync.Once
+	client := newTestClientWithHandler(t, server, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		if r.Method == http.MethodDelete {
+			once.Do(cancel)
+		}
+	}))
+
+	err := client.SyncWithOptions(ctx, "bucket", dir, "backup", gcs.SyncOpts{Delete: true})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("SyncWithOptions() = %v, want context.Canceled", err)
+	}
+	_, stale1 := server.Object("bucket", "backup/stale-1.txt")
+	_, stale2 := server.Object("bucket", "backup/stale-2.txt")
+	if !stale1 && !stale2 {
+		t.Error("both stale objects deleted after the cancellation")
+	}
+}
@@ -0,0 +1,34 @@
+package gcs
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+//throttledWriter writes to w no faster than limiter allows, one token per
+//byte. Writes wait until ctx is done.
+type throttledWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *rate.Limiter
+}
+
+//Write writes b in pieces no larger than the burst of the limiter.
+func (t throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := min(len(b), t.limiter.Burst())
+		if err := t.limiter.WaitN(t.ctx, n); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(b[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
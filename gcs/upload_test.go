@@ -0,0 +1,318 @@
+package gcs_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/jeromeku/go-gcs/gcs"
+	"github.com/jeromeku/go-gcs/gcs/gcsfake"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+//newTestClient returns a client uploading to the buckets of server, and
+//listing and deleting through its handler, configured by opts.
+func newTestClient(t testing.TB, server *gcsfake.Server, opts ...gcs.Option) *gcs.Client {
+	t.Helper()
+	return newTestClientWithHandler(t, server, server.Handler(), opts...)
+}
+
+//newTestClientWithHandler is like newTestClient but serves the requests of
+//the storage client with handler.
+func newTestClientWithHandler(t testing.TB, server *gcsfake.Server, handler http.Handler, opts ...gcs.Option) *gcs.Client {
+	t.Helper()
+	httpServer := httptest.NewServer(handler)
+	t.Cleanup(httpServer.Close)
+	sc, err := storage.NewClient(context.Background(), option.WithoutAuthentication(),
+		option.WithEndpoint(httpServer.URL+"/storage/v1/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sc.Close() })
+
+	opts = append([]gcs.Option{
+		gcs.WithStorageClient(sc),
+		gcs.WithBucketFactory(server.Bucket),
+		gcs.WithProjectID("test-project"),
+		gcs.WithLogger(slog.New(slog.DiscardHandler)),
+	}, opts...)
+	client, err := gcs.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+//writeFile writes data to the file name in a temporary directory and
+//returns its path.
+func writeFile(t testing.TB, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+//gunzip returns the decompressed data.
+func gunzip(t testing.TB, data []byte) []byte {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestUploadCompressesAndKeepsName(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+
+	data := []byte(`{"hello": "world"}`)
+	if err := client.Upload("bucket", writeFile(t, "data.json", data)); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, ok := server.Object("bucket", "data.json")
+	if !ok {
+		t.Fatalf("objects = %q, want data.json", server.ObjectNames("bucket"))
+	}
+	if obj.Attrs.ContentEncoding != "gzip" {
+		t.Errorf("ContentEncoding = %q, want gzip", obj.Attrs.ContentEncoding)
+	}
+	if obj.Attrs.ContentType != "application/json" {
+		t.Errorf("ContentType = %q, want application/json", obj.Attrs.ContentType)
+	}
+	if got := obj.Attrs.Metadata[gcs.OriginalFilenameKey]; got != "data.json" {
+		t.Errorf("original filename = %q, want data.json", got)
+	}
+	if got := gunzip(t, obj.Data); !bytes.Equal(got, data) {
+		t.Errorf("data = %q, want %q", got, data)
+	}
+}
+
+func TestUploadZstd(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+
+	data := bytes.Repeat([]byte("2024-01-01T00:00:00Z INFO request served\n"), 100)
+	opts := gcs.UploadOpts{Compress: gcs.CompressAlways, Encoding: gcs.EncodingZstd}
+	if _, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, "app.log", data), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	obj, ok := server.Object("bucket", "app.log")
+	if !ok {
+		t.Fatalf("objects = %q, want app.log", server.ObjectNames("bucket"))
+	}
+	if obj.Attrs.ContentEncoding != "zstd" {
+		t.Errorf("ContentEncoding = %q, want zstd", obj.Attrs.ContentEncoding)
+	}
+	dec, err := zstd.NewReader(bytes.NewReader(obj.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	got, err := io.ReadAll(dec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("data = %q, want %q", got, data)
+	}
+}
+
+func TestUploadObjectNames(t *testing.T) {
+	tests := []struct {
+		file   string
+		suffix string
+		want   string
+	}{
+		{file: "data.json", want: "data.json"},
+		{file: "archive.tar.gz", want: "archive.tar.gz"},
+		{file: "README", want: "README"},
+		{file: "data.json", suffix: ".gz", want: "data.json.gz"},
+		{file: "README", suffix: ".gz", want: "README.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+tt.suffix, func(t *testing.T) {
+			server := gcsfake.New()
+			server.CreateBucket("bucket")
+			client := newTestClient(t, server)
+
+			opts := gcs.UploadOpts{Compress: gcs.CompressAlways, CompressedSuffix: tt.suffix}
+			result, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, tt.file, []byte("data")), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Name != tt.want {
+				t.Errorf("name = %q, want %q", result.Name, tt.want)
+			}
+			if _, ok := server.Object("bucket", tt.want); !ok {
+				t.Errorf("objects = %q, want %q", server.ObjectNames("bucket"), tt.want)
+			}
+		})
+	}
+}
+
+func TestUploadAlreadyCompressed(t *testing.T) {
+	tests := []struct {
+		file     string
+		encoding gcs.Encoding
+		want     string
+	}{
+		{file: "data.json.gz", want: "gzip"},
+		{file: "data.json.gzip", want: "gzip"},
+		{file: "data.json.zst", want: "zstd"},
+		{file: "data.json.zstd", want: "zstd"},
+		{file: "data.json.zst", encoding: gcs.EncodingGzip, want: "gzip"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+string(tt.encoding), func(t *testing.T) {
+			server := gcsfake.New()
+			server.CreateBucket("bucket")
+			client := newTestClient(t, server)
+
+			data := []byte("compressed")
+			opts := gcs.UploadOpts{AlreadyCompressed: true, Encoding: tt.encoding}
+			if _, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, tt.file, data), opts); err != nil {
+				t.Fatal(err)
+			}
+			obj, ok := server.Object("bucket", tt.file)
+			if !ok {
+				t.Fatalf("objects = %q, want %s", server.ObjectNames("bucket"), tt.file)
+			}
+			if obj.Attrs.ContentEncoding != tt.want {
+				t.Errorf("ContentEncoding = %q, want %s", obj.Attrs.ContentEncoding, tt.want)
+			}
+			if obj.Attrs.ContentType != "application/json" {
+				t.Errorf("ContentType = %q, want application/json", obj.Attrs.ContentType)
+			}
+			if !bytes.Equal(obj.Data, data) {
+				t.Errorf("data = %q, want %q", obj.Data, data)
+			}
+		})
+	}
+}
+
+func TestUploadMissingBucket(t *testing.T) {
+	server := gcsfake.New()
+	client := newTestClient(t, server)
+
+	err := client.Upload("missing", writeFile(t, "data.txt", []byte("data")))
+	if !errors.Is(err, gcs.ErrBucketNotFound) {
+		t.Fatalf("err = %v, want ErrBucketNotFound", err)
+	}
+	if server.HasBucket("missing") {
+		t.Error("bucket created without CreateIfMissing")
+	}
+}
+
+func TestUploadCreateIfMissing(t *testing.T) {
+	server := gcsfake.New()
+	client := newTestClient(t, server)
+
+	opts := gcs.UploadOpts{Bucket: gcs.BucketOpts{CreateIfMissing: true}}
+	if _, err := client.UploadWithOptions(context.Background(), "new-bucket", writeFile(t, "data.txt", []byte("data")), opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Object("new-bucket", "data.txt"); !ok {
+		t.Fatalf("objects = %q, want data.txt", server.ObjectNames("new-bucket"))
+	}
+}
+
+func TestUploadRetriesTransientErrors(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+	server.FailWrites(&googleapi.Error{Code: http.StatusServiceUnavailable}, &googleapi.Error{Code: http.StatusTooManyRequests})
+
+	data := []byte("data")
+	opts := gcs.UploadOpts{Retry: gcs.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	if _, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, "data.txt", data), opts); err != nil {
+		t.Fatal(err)
+	}
+	if obj, ok := server.Object("bucket", "data.txt"); !ok || !bytes.Equal(obj.Data, data) {
+		t.Fatalf("object not written after retries")
+	}
+}
+
+func TestUploadDoesNotRetryPermanentErrors(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+	server.FailWrites(&googleapi.Error{Code: http.StatusForbidden})
+
+	opts := gcs.UploadOpts{Retry: gcs.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	_, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, "data.txt", []byte("data")), opts)
+	if !errors.Is(err, gcs.ErrPermission) {
+		t.Fatalf("err = %v, want ErrPermission", err)
+	}
+	if names := server.ObjectNames("bucket"); len(names) != 0 {
+		t.Errorf("objects = %q, want none", names)
+	}
+}
+
+func TestUploadDoesNotExist(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+	file := writeFile(t, "data.txt", []byte("data"))
+
+	opts := gcs.UploadOpts{DoesNotExist: true}
+	if _, err := client.UploadWithOptions(context.Background(), "bucket", file, opts); err != nil {
+		t.Fatal(err)
+	}
+	_, err := client.UploadWithOptions(context.Background(), "bucket", file, opts)
+	if !errors.Is(err, gcs.ErrPreconditionFailed) {
+		t.Fatalf("err = %v, want ErrPreconditionFailed", err)
+	}
+}
+
+func TestUploadSendCRC32C(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server)
+
+	opts := gcs.UploadOpts{Compress: gcs.CompressAlways, SendCRC32C: true}
+	result, err := client.UploadWithOptions(context.Background(), "bucket", writeFile(t, "data.txt", []byte("data")), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, _ := server.Object("bucket", "data.txt")
+	if result.CRC32C != obj.Attrs.CRC32C {
+		t.Errorf("CRC32C = %08x, stored %08x", result.CRC32C, obj.Attrs.CRC32C)
+	}
+}
+
+func TestUploadDryRun(t *testing.T) {
+	server := gcsfake.New()
+	server.CreateBucket("bucket")
+	client := newTestClient(t, server, gcs.WithDryRun())
+
+	if err := client.Upload("bucket", writeFile(t, "data.txt", []byte("data"))); err != nil {
+		t.Fatal(err)
+	}
+	if names := server.ObjectNames("bucket"); len(names) != 0 {
+		t.Errorf("objects = %q, want none in dry run", names)
+	}
+}